@@ -0,0 +1,340 @@
+//
+//
+// restapi - authenticators
+//
+// An Authenticator adds credentials to each outgoing request.  They can
+// be set on a Client (every request) and/or a single Restapi, and
+// several can be combined - e.g. an api key header plus a bearer token
+//
+//   r.AddAuthenticator(restapi.APIKeyHeader("X-Gateway-Key", key))
+//   r.AddAuthenticator(restapi.BearerAuth(token))
+//
+// Applied in order: client authenticators, client token source, then
+// the request's SetApiKey/SetBearerAccessToken/SetBasicAccessToken,
+// token source and authenticators.  Later ones win when they set the
+// same header
+//
+//
+
+package restapi
+
+import (
+	"encoding/base64"
+	"net/http"
+)
+
+type Authenticator interface {
+  Authenticate(req *http.Request) error
+}
+
+//
+// An Authenticator that can react to a 401 - refresh a token, answer a
+// challenge, etc.  Return true to have the request sent again (once)
+//
+
+type ChallengeAuthenticator interface {
+  Authenticator
+  Challenge(resp *Response) bool
+}
+
+//
+// AuthenticatorFunc lets a plain function be used as an Authenticator
+//
+
+type AuthenticatorFunc func(req *http.Request) error
+
+func (f AuthenticatorFunc) Authenticate(req *http.Request) error {
+  return f(req)
+}
+
+//
+// func BearerAuth(token string) Authenticator
+//
+// Authorization: Bearer <token>
+//
+
+func BearerAuth(token string) Authenticator {
+
+  return AuthenticatorFunc(func(req *http.Request) error {
+    req.Header.Set("Authorization", "Bearer " + token)
+    return nil
+  })
+}
+
+//
+// func BasicAuth(username string, password string) Authenticator
+//
+// Authorization: Basic base64(username:password)
+//
+
+func BasicAuth(username string, password string) Authenticator {
+
+  value := "Basic " + base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+
+  return AuthenticatorFunc(func(req *http.Request) error {
+    req.Header.Set("Authorization", value)
+    return nil
+  })
+}
+
+//
+// func APIKeyHeader(header string, key string) Authenticator
+//
+// Sends key in the named header (x-api-key, X-Gateway-Key, ...)
+//
+
+func APIKeyHeader(header string, key string) Authenticator {
+
+  return AuthenticatorFunc(func(req *http.Request) error {
+    req.Header.Set(header, key)
+    return nil
+  })
+}
+
+//
+// func APIKeyQuery(param string, key string) Authenticator
+//
+// Sends key as a query parameter (?app_code=..., ?api_key=...)
+//
+
+func APIKeyQuery(param string, key string) Authenticator {
+
+  return AuthenticatorFunc(func(req *http.Request) error {
+    q := req.URL.Query()
+    q.Set(param, key)
+    req.URL.RawQuery = q.Encode()
+    return nil
+  })
+}
+
+//
+// func MultiAuth(auths ...Authenticator) Authenticator
+//
+// Applies each of auths in order
+//
+
+func MultiAuth(auths ...Authenticator) Authenticator {
+  return multiAuth(auths)
+}
+
+type multiAuth []Authenticator
+
+func (m multiAuth) Authenticate(req *http.Request) error {
+
+  for _, a := range m {
+    if err := a.Authenticate(req); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+func (m multiAuth) Challenge(resp *Response) bool {
+  return challengeAll(m, resp)
+}
+
+//
+// func TokenAuth(ts TokenSource) Authenticator
+//
+// Authorization header from an oauth2 token source.  On a 401 the
+// cached token is dropped so the retry gets a fresh one
+//
+
+func TokenAuth(ts TokenSource) Authenticator {
+  return tokenAuth{ ts: ts }
+}
+
+type tokenAuth struct {
+  ts TokenSource
+}
+
+func (t tokenAuth) Authenticate(req *http.Request) error {
+
+  tok, err := t.ts.Token(req.Context())
+
+  if(err != nil){
+    return err
+  }
+
+  req.Header.Set("Authorization", tok.authorization())
+
+  return nil
+}
+
+func (t tokenAuth) Challenge(_ *Response) bool {
+
+  inv, ok := t.ts.(TokenInvalidator)
+
+  if(!ok){
+    return false
+  }
+
+  inv.Invalidate()
+
+  return true
+}
+
+//
+// func (c *Client) SetAuthenticator(a Authenticator)
+//
+// Replaces the client's authenticators with a.  nil to remove them all
+//
+
+func (c *Client) SetAuthenticator(a Authenticator){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.aAuthenticators = nil
+
+  if(a != nil){
+    c.aAuthenticators = []Authenticator{ a }
+  }
+}
+
+//
+// func (c *Client) AddAuthenticator(a Authenticator)
+//
+// Adds a to the authenticators applied to every request
+//
+
+func (c *Client) AddAuthenticator(a Authenticator){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.aAuthenticators = append(c.aAuthenticators, a)
+}
+
+//
+// func (c *Client) authenticators() []Authenticator
+//
+// Client level authenticators, token source last
+//
+
+func (c *Client) authenticators() []Authenticator {
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  auths := append([]Authenticator(nil), c.aAuthenticators...)
+
+  if(c.pTokenSource != nil){
+    auths = append(auths, TokenAuth(c.pTokenSource))
+  }
+
+  return auths
+}
+
+//
+// func (pRA *Restapi) SetAuthenticator(a Authenticator)
+//
+// Replaces this request's authenticators with a.  nil to remove them
+// (client authenticators still apply - see SkipClientAuth())
+//
+
+func (pRA *Restapi) SetAuthenticator(a Authenticator){
+
+  pRA.aAuthenticators = nil
+
+  if(a != nil){
+    pRA.aAuthenticators = []Authenticator{ a }
+  }
+}
+
+//
+// func (pRA *Restapi) AddAuthenticator(a Authenticator)
+//
+// Adds another authenticator to this request
+//
+
+func (pRA *Restapi) AddAuthenticator(a Authenticator){
+  pRA.aAuthenticators = append(pRA.aAuthenticators, a)
+}
+
+//
+// func (pRA *Restapi) SkipClientAuth()
+//
+// Don't apply the client's authenticators or token source to this
+// request - e.g. a login call on an otherwise authenticated client
+//
+
+func (pRA *Restapi) SkipClientAuth(){
+  pRA.bNoClientAuth = true
+}
+
+//
+// func (pRA *Restapi) authenticators() []Authenticator
+//
+// Everything to apply to this request, in order
+//
+
+func (pRA *Restapi) authenticators() []Authenticator {
+
+  var auths []Authenticator
+
+  if(!pRA.bNoClientAuth){
+    auths = pRA.pClient.authenticators()
+  }
+
+  if(pRA.bRequiresAccessToken){
+    auths = append(auths, APIKeyHeader("Authorization", pRA.sAccessToken))
+  }
+
+  if(pRA.bRequiresApiKey){
+    auths = append(auths, APIKeyHeader("x-api-key", pRA.sApiKey))
+  }
+
+  if(pRA.pTokenSource != nil){
+    auths = append(auths, TokenAuth(pRA.pTokenSource))
+  }
+
+  return append(auths, pRA.aAuthenticators...)
+}
+
+//
+// func (pRA *Restapi) authenticate(req *http.Request) error
+//
+// Runs the authenticators over req
+//
+
+func (pRA *Restapi) authenticate(req *http.Request) error {
+
+  for _, a := range pRA.authenticators() {
+    if err := a.Authenticate(req); err != nil {
+      return &AuthError{ Name: pRA.sName, Err: err }
+    }
+  }
+
+  return nil
+}
+
+//
+// func (pRA *Restapi) challenge(resp *Response) bool
+//
+// On a 401 let the authenticators have a go at fixing things.  True if
+// any of them want the request sent again
+//
+
+func (pRA *Restapi) challenge(resp *Response) bool {
+
+  if(resp == nil || resp.StatusCode != http.StatusUnauthorized){
+    return false
+  }
+
+  return challengeAll(pRA.authenticators(), resp)
+}
+
+func challengeAll(auths []Authenticator, resp *Response) bool {
+
+  retry := false
+
+  for _, a := range auths {
+    if ca, ok := a.(ChallengeAuthenticator); ok && ca.Challenge(resp) {
+      retry = true
+    }
+  }
+
+  return retry
+}
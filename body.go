@@ -0,0 +1,259 @@
+//
+//
+// restapi - request bodies
+//
+// Setters for the request body that do the encoding and set the right
+// Content-Type, rather than building json by hand for SetPostJson()
+//
+//   r.SetBodyJSON(map[string]any{ "command": "wake_up" })
+//   r.SetBodyForm(url.Values{ "grant_type": { "password" } })
+//   r.SetBodyReader(file, "application/octet-stream")
+//
+// A Content-Type set with SetHeader() wins over the automatic one, and
+// DelHeader("Content-Type") stops it being sent.  Each setter replaces
+// any body set before
+//
+//
+
+package restapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+)
+
+//
+// func (pRA *Restapi) SetBodyJSON(v any) error
+//
+// Marshals v as the body, Content-Type application/json
+//
+
+func (pRA *Restapi) SetBodyJSON(v any) error {
+
+  data, err := json.Marshal(v)
+
+  if(err != nil){
+    return err
+  }
+
+  pRA.setBody(data, "application/json")
+
+  return nil
+}
+
+//
+// func (pRA *Restapi) SetBodyForm(values url.Values)
+//
+// values form encoded as the body, Content-Type
+// application/x-www-form-urlencoded
+//
+
+func (pRA *Restapi) SetBodyForm(values url.Values){
+  pRA.setBody([]byte(values.Encode()), "application/x-www-form-urlencoded")
+}
+
+//
+// func (pRA *Restapi) SetBodyXML(v any) error
+//
+// Marshals v (with the <?xml ...?> header) as the body, Content-Type
+// application/xml
+//
+
+func (pRA *Restapi) SetBodyXML(v any) error {
+
+  data, err := xml.Marshal(v)
+
+  if(err != nil){
+    return err
+  }
+
+  pRA.setBody(append([]byte(xml.Header), data...), "application/xml")
+
+  return nil
+}
+
+//
+// func (pRA *Restapi) SetBodyBytes(data []byte, contentType string)
+//
+// data as is.  contentType "" means application/octet-stream
+//
+
+func (pRA *Restapi) SetBodyBytes(data []byte, contentType string){
+
+  if(contentType == ""){
+    contentType = "application/octet-stream"
+  }
+
+  pRA.setBody(data, contentType)
+}
+
+//
+// func (pRA *Restapi) SetBodyReader(body io.Reader, contentType string)
+//
+// Streams body rather than holding it in memory.  It can only be read
+// once, so the request is not retried and has to be given a new reader
+// before being sent again.  body stays the caller's to close - an
+// *os.File is still open after sending.  Digest auth-int and signers
+// need a body they can re-read - use SetBodyBytes() with those.
+// contentType "" means application/octet-stream
+//
+
+func (pRA *Restapi) SetBodyReader(body io.Reader, contentType string){
+
+  pRA.clearBody()
+
+  if(contentType == ""){
+    contentType = "application/octet-stream"
+  }
+
+  pRA.pBodyReader = body
+  pRA.sBodyType = contentType
+}
+
+//
+// func (pRA *Restapi) ClearBody()
+//
+// Send the request without a body
+//
+
+func (pRA *Restapi) ClearBody(){
+  pRA.clearBody()
+}
+
+func (pRA *Restapi) clearBody(){
+
+  pRA.bHasPostJson = false
+  pRA.sJsonStr = ""
+  pRA.aBody = nil
+  pRA.pBodyReader = nil
+  pRA.pMultipart = nil
+  pRA.bBodySent = false
+  pRA.sBodyType = ""
+}
+
+//
+// func (pRA *Restapi) setBody(data []byte, contentType string)
+//
+// An in memory body.  Text bodies are also kept in sJsonStr so Dump()
+// and debug output show them
+//
+
+func (pRA *Restapi) setBody(data []byte, contentType string){
+
+  pRA.clearBody()
+
+  if(data == nil){
+    data = []byte{}
+  }
+
+  pRA.aBody = data
+  pRA.sBodyType = contentType
+
+  if(contentType != "application/octet-stream"){
+    pRA.sJsonStr = string(data)
+  }
+}
+
+//
+// func (pRA *Restapi) bodyReader() (io.Reader, error)
+//
+// The body for the next attempt, nil for none
+//
+
+func (pRA *Restapi) bodyReader() (io.Reader, error) {
+
+  switch {
+    case pRA.pBodyReader != nil:
+      if(pRA.bBodySent){
+        return nil, errors.New("body stream already sent - call SetBodyReader() again")
+      }
+      pRA.bBodySent = true
+      if _, ok := pRA.pBodyReader.(io.Closer); ok {
+        return io.NopCloser(pRA.pBodyReader), nil // net/http closes a body it's given
+      }
+      return pRA.pBodyReader, nil
+
+    case pRA.pMultipart != nil:
+      return pRA.pMultipart.reader(), nil
+
+    case pRA.bHasPostJson:
+      return bytes.NewBufferString(pRA.sJsonStr), nil
+
+    case pRA.aBody != nil:
+      return bytes.NewReader(pRA.aBody), nil
+  }
+
+  return nil, nil
+}
+
+//
+// func (pRA *Restapi) bodyLength() int64
+//
+// Size of a streamed body when we can tell - multipart with known part
+// sizes or a regular file given to SetBodyReader().  -1 otherwise (in
+// memory bodies are sized by net/http).  S3 and a lot of embedded
+// servers refuse chunked uploads
+//
+
+func (pRA *Restapi) bodyLength() int64 {
+
+  if(pRA.pMultipart != nil){
+    return pRA.pMultipart.length()
+  }
+
+  f, ok := pRA.pBodyReader.(*os.File)
+
+  if(!ok){
+    return -1
+  }
+
+  info, err := f.Stat()
+
+  if(err != nil || !info.Mode().IsRegular()){
+    return -1
+  }
+
+  offset, err := f.Seek(0, io.SeekCurrent)
+
+  if(err != nil){
+    return -1
+  }
+
+  return info.Size() - offset
+}
+
+//
+// func (pRA *Restapi) abandonBody(body io.Reader)
+//
+// For a body made by bodyReader() that won't be sent after all.  A
+// multipart body is already being written by a goroutine with a file
+// open - closing the pipe stops it.  A SetBodyReader() stream is the
+// caller's to close
+//
+
+func (pRA *Restapi) abandonBody(body io.Reader){
+
+  if c, ok := body.(io.Closer); ok && pRA.pMultipart != nil {
+    c.Close()
+  }
+}
+
+//
+// func (pRA *Restapi) canResend() bool
+//
+// False if the body is a stream that can only be sent once
+//
+
+func (pRA *Restapi) canResend() bool {
+
+  if(pRA.pMultipart != nil){
+    return pRA.pMultipart.replayable()
+  }
+
+  return pRA.pBodyReader == nil
+}
@@ -0,0 +1,125 @@
+package restapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//
+// echoServer records the last request's Content-Type and body
+//
+
+type echoRecord struct {
+	contentType []string
+	body        string
+}
+
+func echoServer(rec *echoRecord) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec.contentType = r.Header.Values("Content-Type")
+		data, _ := io.ReadAll(r.Body)
+		rec.body = string(data)
+		w.Write([]byte(`{}`))
+	}))
+}
+
+func TestBodyContentType(t *testing.T) {
+
+	var rec echoRecord
+
+	srv := echoServer(&rec)
+	defer srv.Close()
+
+	tests := []struct {
+		name  string
+		setup func(r *Restapi)
+		ctype []string
+		body  string
+	}{
+		{"json", func(r *Restapi) { r.SetBodyJSON(map[string]int{"a": 1}) },
+			[]string{"application/json"}, `{"a":1}`},
+		{"form", func(r *Restapi) { r.SetBodyForm(url.Values{"b": {"2"}}) },
+			[]string{"application/x-www-form-urlencoded"}, "b=2"},
+		{"bytes", func(r *Restapi) { r.SetBodyBytes([]byte{1, 2}, "") },
+			[]string{"application/octet-stream"}, "\x01\x02"},
+		{"SetHeader wins", func(r *Restapi) {
+			r.SetHeader("Content-Type", "application/vnd.api+json")
+			r.SetBodyJSON(map[string]int{"a": 1})
+		}, []string{"application/vnd.api+json"}, `{"a":1}`},
+		{"DelHeader before", func(r *Restapi) {
+			r.DelHeader("Content-Type")
+			r.SetBodyJSON(map[string]int{"a": 1})
+		}, nil, `{"a":1}`},
+		{"DelHeader after", func(r *Restapi) {
+			r.SetBodyForm(url.Values{"b": {"2"}})
+			r.DelHeader("Content-Type")
+		}, nil, "b=2"},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			r := NewPost("body", srv.URL)
+			r.JsonOnly()
+			tt.setup(r)
+
+			if _, err := r.Do(); err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+
+			if len(rec.contentType) != len(tt.ctype) || (len(tt.ctype) > 0 && rec.contentType[0] != tt.ctype[0]) {
+				t.Errorf("Content-Type = %v, want %v", rec.contentType, tt.ctype)
+			}
+
+			if rec.body != tt.body {
+				t.Errorf("body = %q, want %q", rec.body, tt.body)
+			}
+		})
+	}
+}
+
+func TestBodyReaderLeftOpen(t *testing.T) {
+
+	var rec echoRecord
+
+	srv := echoServer(&rec)
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "upload")
+
+	if err := os.WriteFile(path, []byte("file body"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	r := NewPut("upload", srv.URL)
+	r.SetBodyReader(f, "text/plain")
+
+	if !r.Send() {
+		t.Fatalf("send failed, status %d", r.GetLastStatusCode())
+	}
+
+	if rec.body != "file body" {
+		t.Errorf("body = %q", rec.body)
+	}
+
+	// still the caller's - seek back and use it again
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("file was closed: %v", err)
+	}
+}
@@ -0,0 +1,570 @@
+//
+//
+// restapi - client
+//
+// A Client holds the long lived http transport that requests are sent
+// over so keep-alive connections (and TLS sessions) get reused between
+// Send() calls instead of being set up every time.  Polling loops
+// should create their requests from one Client
+//
+// New(), NewGet(), etc use DefaultClient
+//
+//
+
+package restapi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type Client struct {
+
+  mu sync.Mutex
+
+  nMaxIdleConns        int
+  nMaxIdleConnsPerHost int
+  dIdleConnTimeout     time.Duration
+  bHTTP2               bool
+
+  pTLSOptions *TLSOptions // nil = go defaults
+
+  dTimeout time.Duration // default timeout given to new requests
+
+  nMaxBodySize int64 // default buffered body limit, 0 = none
+
+  hHeaders http.Header // default headers for every request
+
+  pTokenSource    TokenSource     // oauth2 tokens for every request
+  aAuthenticators []Authenticator // applied to every request
+  pSigner         Signer          // signs every request
+
+  pRedactor *redactor // what Dump(), debug output and errors mask
+
+  pTransport      *http.Transport
+  mCertTransports map[string]*certTransport // keyed by UseCert() file
+  mTLSTransports  map[string]*http.Transport // for requests with their own TLSOptions
+
+}
+
+// options that keep changing (a new RootCAs pool for every request)
+// would otherwise build transports forever
+
+const maxTLSTransports = 32
+
+//
+// A transport trusting a UseCert() file - reloaded if the file changes
+//
+
+type certTransport struct {
+  tModTime   time.Time
+  pTransport *http.Transport
+}
+
+var DefaultClient = NewClient()
+
+//
+// func NewClient() *Client
+//
+// Creates a client with a tuned connection pool - 100 idle connections,
+// 10 per host, 90 second idle timeout, HTTP/2 when the server offers it
+// and a 10 second default request timeout
+//
+
+func NewClient() *Client {
+
+  c := new(Client)
+
+  c.nMaxIdleConns = 100
+  c.nMaxIdleConnsPerHost = 10
+  c.dIdleConnTimeout = 90 * time.Second
+  c.bHTTP2 = true
+  c.pTLSOptions = nil
+
+  c.dTimeout = time.Second * 10
+
+  c.hHeaders = http.Header{ "Cache-Control": { "no-cache" },
+                            "Content-Type": { "application/json" } }
+
+  c.pRedactor = newRedactor()
+
+  return c
+}
+
+//
+// func (c *Client) New(method HttpMethod, name string, url string) *Restapi
+//
+// Create a new restapi object that sends over this client
+//
+// method - Type of http method (get, put, delete, etc)
+// name - name of the request
+// url - URL to execute against
+//
+
+func (c *Client) New(method HttpMethod, name string, url string) *Restapi {
+
+  r := newRestapi(method, name, url)
+
+  r.pClient = c
+  r.dTimeout = c.GetTimeout()
+
+  c.mu.Lock()
+  r.nMaxBodySize = c.nMaxBodySize
+  c.mu.Unlock()
+
+  return r
+}
+
+//
+// func (c *Client) NewGet(name string, url string) *Restapi
+//
+// Create a new GET request on this client
+//
+
+func (c *Client) NewGet(name string, url string) *Restapi {
+  return c.New(Get, name, url)
+}
+
+//
+// func (c *Client) NewPost(name string, url string) *Restapi
+//
+// Create a new POST request on this client
+//
+
+func (c *Client) NewPost(name string, url string) *Restapi {
+  return c.New(Post, name, url)
+}
+
+//
+// func (c *Client) NewPut(name string, url string) *Restapi
+//
+// Create a new PUT request on this client
+//
+
+func (c *Client) NewPut(name string, url string) *Restapi {
+  return c.New(Put, name, url)
+}
+
+//
+// func (c *Client) NewDelete(name string, url string) *Restapi
+//
+// Create a new DELETE request on this client
+//
+
+func (c *Client) NewDelete(name string, url string) *Restapi {
+  return c.New(Delete, name, url)
+}
+
+//
+// func (c *Client) NewPatch(name string, url string) *Restapi
+//
+// Create a new PATCH request on this client
+//
+
+func (c *Client) NewPatch(name string, url string) *Restapi {
+  return c.New(Patch, name, url)
+}
+
+//
+// func (c *Client) NewHead(name string, url string) *Restapi
+//
+// Create a new HEAD request on this client
+//
+
+func (c *Client) NewHead(name string, url string) *Restapi {
+  return c.New(Head, name, url)
+}
+
+//
+// func (c *Client) NewOptions(name string, url string) *Restapi
+//
+// Create a new OPTIONS request on this client
+//
+
+func (c *Client) NewOptions(name string, url string) *Restapi {
+  return c.New(Options, name, url)
+}
+
+//
+// func (c *Client) NewMethod(verb string, name string, url string) *Restapi
+//
+// Create a request on this client for any other http verb
+//
+
+func (c *Client) NewMethod(verb string, name string, url string) *Restapi {
+
+  r := c.New(Custom, name, url)
+  r.SetMethodString(verb)
+
+  return r
+}
+
+//
+// func (c *Client) SetTimeout(timeout time.Duration)
+//
+// Timeout given to requests created from this client after the call
+//
+// timeout - 0 means no limit
+//
+
+func (c *Client) SetTimeout(timeout time.Duration){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.dTimeout = timeout
+}
+
+//
+// func (c *Client) GetTimeout() time.Duration
+//
+// Returns the timeout given to new requests
+//
+
+func (c *Client) GetTimeout() time.Duration{
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  return c.dTimeout
+}
+
+//
+// func (c *Client) SetMaxIdleConns(total int, perHost int)
+//
+// Size of the keep-alive pool
+//
+// total - idle connections kept across all hosts (0 = no limit)
+// perHost - idle connections kept per host
+//
+
+func (c *Client) SetMaxIdleConns(total int, perHost int){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.nMaxIdleConns = total
+  c.nMaxIdleConnsPerHost = perHost
+  c.resetTransports()
+}
+
+//
+// func (c *Client) SetIdleConnTimeout(timeout time.Duration)
+//
+// How long an unused connection is kept open
+//
+
+func (c *Client) SetIdleConnTimeout(timeout time.Duration){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.dIdleConnTimeout = timeout
+  c.resetTransports()
+}
+
+//
+// func (c *Client) SetHTTP2(enabled bool)
+//
+// HTTP/2 is used when the server offers it unless turned off here
+//
+
+func (c *Client) SetHTTP2(enabled bool){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.bHTTP2 = enabled
+  c.resetTransports()
+}
+
+//
+// func (c *Client) CloseIdleConnections()
+//
+// Closes any keep-alive connections sitting unused
+//
+
+func (c *Client) CloseIdleConnections(){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if(c.pTransport != nil){
+    c.pTransport.CloseIdleConnections()
+  }
+
+  for _, ct := range c.mCertTransports {
+    ct.pTransport.CloseIdleConnections()
+  }
+
+  for _, tran := range c.mTLSTransports {
+    tran.CloseIdleConnections()
+  }
+}
+
+//
+// func (c *Client) SetDefaultHeader(name string, value string)
+//
+// Sets a header sent on every request from this client
+//
+
+func (c *Client) SetDefaultHeader(name string, value string){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.hHeaders.Set(name, value)
+}
+
+//
+// func (c *Client) AddDefaultHeader(name string, value string)
+//
+// Adds a value to a header sent on every request from this client
+//
+
+func (c *Client) AddDefaultHeader(name string, value string){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.hHeaders.Add(name, value)
+}
+
+//
+// func (c *Client) DelDefaultHeader(name string)
+//
+// Stops a header being sent on every request from this client -
+// including the built in cache-control and Content-Type
+//
+
+func (c *Client) DelDefaultHeader(name string){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.hHeaders.Del(name)
+}
+
+//
+// func (c *Client) GetDefaultHeaders() http.Header
+//
+// Returns a copy of this client's default headers
+//
+
+func (c *Client) GetDefaultHeaders() http.Header{
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  return c.hHeaders.Clone()
+}
+
+//
+// func (c *Client) transport() *http.Transport
+//
+// The shared transport, built on first use
+//
+
+func (c *Client) transport() *http.Transport {
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if(c.pTransport == nil){
+    c.pTransport = c.newTransport(c.pTLSOptions)
+  }
+
+  return c.pTransport
+}
+
+//
+// func (c *Client) certTransport(certfile string) (*http.Transport, error)
+//
+// Transport trusting the CA in certfile.  Kept and reused until the
+// file changes on disk
+//
+
+func (c *Client) certTransport(certfile string) (*http.Transport, error) {
+
+  info, err := os.Stat(certfile)
+
+  if(err != nil){
+    return nil, &CertError{ File: certfile, Err: err }
+  }
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if ct, ok := c.mCertTransports[certfile]; ok && ct.tModTime.Equal(info.ModTime()) {
+    return ct.pTransport, nil
+  }
+
+  pool, err := loadCertPool(certfile)
+
+  if(err != nil){
+    return nil, err
+  }
+
+  if(c.mCertTransports == nil){
+    c.mCertTransports = make(map[string]*certTransport)
+  }
+
+  if old, ok := c.mCertTransports[certfile]; ok {
+    old.pTransport.CloseIdleConnections()
+  }
+
+  opts := c.pTLSOptions.Clone()
+  opts.RootCAs = pool
+
+  ct := &certTransport{ tModTime: info.ModTime(), pTransport: c.newTransport(opts) }
+
+  c.mCertTransports[certfile] = ct
+
+  return ct.pTransport, nil
+}
+
+//
+// func (c *Client) tlsTransport(opts *TLSOptions, certfile string) (*http.Transport, error)
+//
+// Transport for requests with TLS settings of their own, shared by all
+// of them with the same settings so a new request per poll still reuses
+// connections.  certfile (UseCert()) is trusted when opts has no
+// RootCAs, and reloaded if it changes on disk
+//
+
+func (c *Client) tlsTransport(opts *TLSOptions, certfile string) (*http.Transport, error) {
+
+  key := opts.cacheKey()
+
+  if(certfile != "" && opts.RootCAs == nil){
+    info, err := os.Stat(certfile)
+
+    if(err != nil){
+      return nil, &CertError{ File: certfile, Err: err }
+    }
+
+    key += fmt.Sprintf("|cert %q %d", certfile, info.ModTime().UnixNano())
+  }else{
+    certfile = ""
+  }
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if tran, ok := c.mTLSTransports[key]; ok {
+    return tran, nil
+  }
+
+  if(certfile != ""){
+    pool, err := loadCertPool(certfile)
+
+    if(err != nil){
+      return nil, err
+    }
+
+    opts = opts.Clone()
+    opts.RootCAs = pool
+  }
+
+  if(c.mTLSTransports == nil){
+    c.mTLSTransports = make(map[string]*http.Transport)
+  }
+
+  if(len(c.mTLSTransports) >= maxTLSTransports){
+    for k, old := range c.mTLSTransports { // any one will do
+      old.CloseIdleConnections()
+      delete(c.mTLSTransports, k)
+      break
+    }
+  }
+
+  tran := c.newTransport(opts)
+
+  c.mTLSTransports[key] = tran
+
+  return tran, nil
+}
+
+//
+// func (c *Client) upgradeTransport(opts *TLSOptions) *http.Transport
+//
+// A one off HTTP/1.1 only transport for a connection upgrade (websocket)
+// - HTTP/2 has no 101 Switching Protocols
+//
+
+func (c *Client) upgradeTransport(opts *TLSOptions) *http.Transport {
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  tran := c.newTransport(opts)
+
+  tran.ForceAttemptHTTP2 = false
+  tran.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+
+  if(tran.TLSClientConfig != nil){
+    tran.TLSClientConfig.NextProtos = []string{ "http/1.1" }
+  }
+
+  return tran
+}
+
+//
+// func (c *Client) newTransport(opts *TLSOptions) *http.Transport
+//
+// Builds a transport with our pool settings - c.mu must be held
+//
+
+func (c *Client) newTransport(opts *TLSOptions) *http.Transport {
+
+  dialer := &net.Dialer{ Timeout: 30 * time.Second, KeepAlive: 30 * time.Second }
+
+  tran := &http.Transport{ Proxy: http.ProxyFromEnvironment,
+                           DialContext: dialer.DialContext,
+                           ForceAttemptHTTP2: c.bHTTP2,
+                           MaxIdleConns: c.nMaxIdleConns,
+                           MaxIdleConnsPerHost: c.nMaxIdleConnsPerHost,
+                           IdleConnTimeout: c.dIdleConnTimeout,
+                           TLSHandshakeTimeout: 10 * time.Second,
+                           ExpectContinueTimeout: 1 * time.Second }
+
+  tran.TLSClientConfig = opts.tlsConfig()
+
+  if(!c.bHTTP2){
+    // an empty (non nil) map is how net/http is told no HTTP/2
+    tran.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+  }
+
+  return tran
+}
+
+//
+// func (c *Client) resetTransports()
+//
+// Drops the built transports so the next request picks up new
+// settings - c.mu must be held
+//
+
+func (c *Client) resetTransports(){
+
+  if(c.pTransport != nil){
+    c.pTransport.CloseIdleConnections()
+    c.pTransport = nil
+  }
+
+  for _, ct := range c.mCertTransports {
+    ct.pTransport.CloseIdleConnections()
+  }
+
+  for _, tran := range c.mTLSTransports {
+    tran.CloseIdleConnections()
+  }
+
+  c.mCertTransports = nil
+  c.mTLSTransports = nil
+}
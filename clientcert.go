@@ -0,0 +1,468 @@
+//
+//
+// restapi - client certificates (mutual TLS)
+//
+// Some device and internal apis want us to present a certificate as
+// well as check theirs.  A ClientCert can come from PEM files (reloaded
+// automatically when the files change on disk - handy for short lived
+// certs rotated by another process), PEM bytes or a ready made
+// tls.Certificate.  Encrypted keys are supported, both the traditional
+// "Proc-Type: 4,ENCRYPTED" kind and PKCS#8 "ENCRYPTED PRIVATE KEY"
+// (PBES2/PBKDF2 with AES-CBC, what openssl produces today)
+//
+//   cc, err := restapi.ClientCertFromFiles("client.crt", "client.key", nil)
+//   client.SetClientCert(cc)
+//
+//
+
+package restapi
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/seldonsmule/logmsg"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var ErrKeyPassword = errors.New("restapi: incorrect password for private key")
+
+type ClientCert struct {
+
+  mu sync.Mutex
+
+  sCertFile string // empty when not loaded from files
+  sKeyFile  string
+  bPassword []byte
+
+  tCertModTime time.Time
+  tKeyModTime  time.Time
+
+  pCert *tls.Certificate
+
+}
+
+//
+// func ClientCertFromFiles(certfile string, keyfile string, password []byte) (*ClientCert, error)
+//
+// Loads a certificate (chain) and private key from PEM files.  The files
+// are checked on each new connection and reloaded if they changed
+//
+// certfile - PEM certificate, may include intermediates
+// keyfile - PEM private key, may be the same file as certfile
+// password - for an encrypted key, nil if not encrypted
+//
+
+func ClientCertFromFiles(certfile string, keyfile string, password []byte) (*ClientCert, error) {
+
+  cc := &ClientCert{ sCertFile: certfile,
+                     sKeyFile: keyfile,
+                     bPassword: append([]byte(nil), password...) }
+
+  err := cc.reload()
+
+  if(err != nil){
+    return nil, err
+  }
+
+  return cc, nil
+}
+
+//
+// func ClientCertFromPEM(certPEM []byte, keyPEM []byte, password []byte) (*ClientCert, error)
+//
+// Same as ClientCertFromFiles() but from PEM already in memory
+//
+
+func ClientCertFromPEM(certPEM []byte, keyPEM []byte, password []byte) (*ClientCert, error) {
+
+  cert, err := parseClientCert(certPEM, keyPEM, password)
+
+  if(err != nil){
+    return nil, &CertError{ File: "(memory)", Err: err }
+  }
+
+  return &ClientCert{ pCert: cert }, nil
+}
+
+//
+// func ClientCertFromTLS(cert tls.Certificate) *ClientCert
+//
+// Wraps a certificate already loaded (e.g. from an HSM or tls.LoadX509KeyPair)
+//
+
+func ClientCertFromTLS(cert tls.Certificate) *ClientCert {
+  return &ClientCert{ pCert: &cert }
+}
+
+//
+// func (cc *ClientCert) Certificate() *tls.Certificate
+//
+// The certificate currently in use
+//
+
+func (cc *ClientCert) Certificate() *tls.Certificate {
+
+  cc.mu.Lock()
+  defer cc.mu.Unlock()
+
+  return cc.pCert
+}
+
+//
+// func (c *Client) SetClientCert(cc *ClientCert)
+//
+// Present cc to servers asking for a client certificate.  nil to stop
+//
+
+func (c *Client) SetClientCert(cc *ClientCert){
+
+  opts := c.GetTLSOptions()
+  opts.ClientCert = cc
+
+  c.SetTLSOptions(opts)
+}
+
+//
+// func (pRA *Restapi) SetClientCert(cc *ClientCert)
+//
+// Present cc for this request only.  nil to stop
+//
+
+func (pRA *Restapi) SetClientCert(cc *ClientCert){
+
+  opts := pRA.GetTLSOptions()
+  opts.ClientCert = cc
+
+  pRA.SetTLSOptions(opts)
+}
+
+//
+// func (cc *ClientCert) cacheKey() string
+//
+// Identifies cc for TLSOptions.cacheKey() - file based certs by their
+// files (they reload themselves), others by identity
+//
+
+func (cc *ClientCert) cacheKey() string {
+
+  if(cc == nil){
+    return ""
+  }
+
+  if(cc.sCertFile != ""){
+    return fmt.Sprintf("files %q %q", cc.sCertFile, cc.sKeyFile)
+  }
+
+  return fmt.Sprintf("%p", cc)
+}
+
+//
+// func (cc *ClientCert) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+//
+// tls.Config callback.  For file based certs, picks up changed files -
+// if the new files don't load we keep going with the old certificate
+//
+
+func (cc *ClientCert) getClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+
+  if(cc.sCertFile != "" && cc.changed()){
+    err := cc.reload()
+
+    if(err != nil){
+      logmsg.Print(logmsg.Warning, "Client certificate reload failed, using previous:", err)
+    }
+  }
+
+  return cc.Certificate(), nil
+}
+
+//
+// func (cc *ClientCert) changed() bool
+//
+// Have the cert or key files been modified since we loaded them
+//
+
+func (cc *ClientCert) changed() bool {
+
+  certInfo, err := os.Stat(cc.sCertFile)
+
+  if(err != nil){
+    return false
+  }
+
+  keyInfo, err := os.Stat(cc.sKeyFile)
+
+  if(err != nil){
+    return false
+  }
+
+  cc.mu.Lock()
+  defer cc.mu.Unlock()
+
+  return !certInfo.ModTime().Equal(cc.tCertModTime) ||
+         !keyInfo.ModTime().Equal(cc.tKeyModTime)
+}
+
+//
+// func (cc *ClientCert) reload() error
+//
+// (Re)reads the cert and key files
+//
+
+func (cc *ClientCert) reload() error {
+
+  certInfo, err := os.Stat(cc.sCertFile)
+
+  if(err != nil){
+    return &CertError{ File: cc.sCertFile, Err: err }
+  }
+
+  keyInfo, err := os.Stat(cc.sKeyFile)
+
+  if(err != nil){
+    return &CertError{ File: cc.sKeyFile, Err: err }
+  }
+
+  certPEM, err := os.ReadFile(cc.sCertFile)
+
+  if(err != nil){
+    return &CertError{ File: cc.sCertFile, Err: err }
+  }
+
+  keyPEM, err := os.ReadFile(cc.sKeyFile)
+
+  if(err != nil){
+    return &CertError{ File: cc.sKeyFile, Err: err }
+  }
+
+  cert, err := parseClientCert(certPEM, keyPEM, cc.bPassword)
+
+  if(err != nil){
+    return &CertError{ File: cc.sCertFile, Err: err }
+  }
+
+  cc.mu.Lock()
+  defer cc.mu.Unlock()
+
+  cc.pCert = cert
+  cc.tCertModTime = certInfo.ModTime()
+  cc.tKeyModTime = keyInfo.ModTime()
+
+  return nil
+}
+
+//
+// func parseClientCert(certPEM []byte, keyPEM []byte, password []byte) (*tls.Certificate, error)
+//
+// Decrypts the key if needed then hands off to tls.X509KeyPair which
+// also checks the key matches the certificate
+//
+
+func parseClientCert(certPEM []byte, keyPEM []byte, password []byte) (*tls.Certificate, error) {
+
+  var plain []byte
+
+  rest := keyPEM
+
+  for {
+    var block *pem.Block
+
+    block, rest = pem.Decode(rest)
+
+    if(block == nil){
+      break
+    }
+
+    switch {
+
+      case block.Type == "ENCRYPTED PRIVATE KEY":
+        der, err := decryptPKCS8(block.Bytes, password)
+
+        if(err != nil){
+          return nil, err
+        }
+
+        block = &pem.Block{ Type: "PRIVATE KEY", Bytes: der }
+
+      case x509.IsEncryptedPEMBlock(block):
+        // RFC 1423 "Proc-Type: 4,ENCRYPTED" keys are deprecated in
+        // crypto/x509 (no integrity check, weak key derivation) but
+        // older openssl, OpenVPN and appliance exports still hand them
+        // out, so we keep reading them
+        der, err := x509.DecryptPEMBlock(block, password) //nolint:staticcheck
+
+        if(err != nil){
+          if(errors.Is(err, x509.IncorrectPasswordError)){
+            return nil, ErrKeyPassword
+          }
+          return nil, err
+        }
+
+        block = &pem.Block{ Type: block.Type, Bytes: der }
+
+      case block.Type == "CERTIFICATE":
+        continue // cert and key in the same file
+    }
+
+    plain = append(plain, pem.EncodeToMemory(block)...)
+  }
+
+  if(len(plain) == 0){
+    return nil, fmt.Errorf("no PEM private key found")
+  }
+
+  cert, err := tls.X509KeyPair(certPEM, plain)
+
+  if(err != nil){
+    return nil, err
+  }
+
+  return &cert, nil
+}
+
+//
+// PKCS#8 encrypted private keys (RFC 5208 / RFC 8018 PBES2)
+//
+
+var (
+  oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+  oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+  oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+  oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+  oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+  oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+  oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type encryptedPrivateKeyInfo struct {
+  Algo          pkix.AlgorithmIdentifier
+  EncryptedData []byte
+}
+
+type pbes2Params struct {
+  KeyDerivationFunc pkix.AlgorithmIdentifier
+  EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+  Salt           []byte
+  IterationCount int
+  KeyLength      int                      `asn1:"optional"`
+  PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+//
+// func decryptPKCS8(der []byte, password []byte) ([]byte, error)
+//
+// Returns the plain PKCS#8 DER of an ENCRYPTED PRIVATE KEY
+//
+
+func decryptPKCS8(der []byte, password []byte) ([]byte, error) {
+
+  var info encryptedPrivateKeyInfo
+
+  if _, err := asn1.Unmarshal(der, &info); err != nil {
+    return nil, err
+  }
+
+  if(!info.Algo.Algorithm.Equal(oidPBES2)){
+    return nil, fmt.Errorf("unsupported key encryption %s", info.Algo.Algorithm)
+  }
+
+  var params pbes2Params
+
+  if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+    return nil, err
+  }
+
+  if(!params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2)){
+    return nil, fmt.Errorf("unsupported key derivation %s", params.KeyDerivationFunc.Algorithm)
+  }
+
+  var kdf pbkdf2Params
+
+  if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+    return nil, err
+  }
+
+  var keyLen int
+
+  switch {
+    case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+      keyLen = 16
+    case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+      keyLen = 24
+    case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+      keyLen = 32
+    default:
+      return nil, fmt.Errorf("unsupported key cipher %s", params.EncryptionScheme.Algorithm)
+  }
+
+  prf := sha1.New
+
+  switch {
+    case len(kdf.PRF.Algorithm) == 0 || kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+    case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+      prf = sha256.New
+    default:
+      return nil, fmt.Errorf("unsupported key prf %s", kdf.PRF.Algorithm)
+  }
+
+  var iv []byte
+
+  if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+    return nil, err
+  }
+
+  if(kdf.IterationCount <= 0 || len(kdf.Salt) == 0){
+    return nil, fmt.Errorf("malformed encrypted private key")
+  }
+
+  key := pbkdf2.Key(password, kdf.Salt, kdf.IterationCount, keyLen, prf)
+
+  block, err := aes.NewCipher(key)
+
+  if(err != nil){
+    return nil, err
+  }
+
+  if(len(iv) != block.BlockSize() || len(info.EncryptedData) == 0 ||
+     len(info.EncryptedData) % block.BlockSize() != 0){
+    return nil, fmt.Errorf("malformed encrypted private key")
+  }
+
+  plain := make([]byte, len(info.EncryptedData))
+
+  cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+
+  // a wrong password shows up as bad padding (or garbage der)
+
+  pad := int(plain[len(plain)-1])
+
+  if(pad == 0 || pad > block.BlockSize() ||
+     !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad))){
+    return nil, ErrKeyPassword
+  }
+
+  plain = plain[:len(plain)-pad]
+
+  if _, err := x509.ParsePKCS8PrivateKey(plain); err != nil {
+    return nil, ErrKeyPassword
+  }
+
+  return plain, nil
+}
@@ -0,0 +1,85 @@
+package restapi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//
+// Keys in testdata were made from one P-256 key with
+//
+//   openssl pkcs8 -topk8 -v2 aes-256-cbc -v2prf hmacWithSHA1   (client_pbes2_sha1.key)
+//   openssl pkcs8 -topk8 -v2 aes-256-cbc -v2prf hmacWithSHA256 (client_pbes2_sha256.key)
+//   openssl pkcs8 -topk8 -v2 aes-128-cbc                       (client_pbes2_aes128.key)
+//   openssl pkey -traditional -aes256                          (client_legacy.key)
+//
+// all with the password "s3cret"
+//
+
+func readTestdata(t *testing.T, name string) []byte {
+
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestClientCertEncryptedKeys(t *testing.T) {
+
+	certPEM := readTestdata(t, "client.crt")
+
+	for _, name := range []string{"client_pbes2_sha1.key", "client_pbes2_sha256.key",
+		"client_pbes2_aes128.key", "client_legacy.key"} {
+
+		t.Run(name, func(t *testing.T) {
+
+			cc, err := ClientCertFromPEM(certPEM, readTestdata(t, name), []byte("s3cret"))
+
+			if err != nil {
+				t.Fatalf("ClientCertFromPEM: %v", err)
+			}
+
+			if cc.Certificate() == nil || cc.Certificate().PrivateKey == nil {
+				t.Fatal("no private key loaded")
+			}
+		})
+	}
+}
+
+func TestClientCertWrongPassword(t *testing.T) {
+
+	certPEM := readTestdata(t, "client.crt")
+
+	for _, name := range []string{"client_pbes2_sha1.key", "client_pbes2_sha256.key", "client_legacy.key"} {
+
+		t.Run(name, func(t *testing.T) {
+
+			_, err := ClientCertFromPEM(certPEM, readTestdata(t, name), []byte("wrong"))
+
+			if !errors.Is(err, ErrKeyPassword) {
+				t.Fatalf("err = %v, want ErrKeyPassword", err)
+			}
+		})
+	}
+}
+
+func TestClientCertFromFiles(t *testing.T) {
+
+	cc, err := ClientCertFromFiles(filepath.Join("testdata", "client.crt"),
+		filepath.Join("testdata", "client_pbes2_sha256.key"), []byte("s3cret"))
+
+	if err != nil {
+		t.Fatalf("ClientCertFromFiles: %v", err)
+	}
+
+	if cc.Certificate() == nil {
+		t.Fatal("no certificate")
+	}
+}
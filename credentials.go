@@ -0,0 +1,423 @@
+//
+//
+// restapi - credential providers
+//
+// Lets tools pick up their tokens, api keys and passwords from the
+// environment, a secrets file or ~/.netrc instead of every caller
+// reading them and calling SetApiKey()/SetBearerAccessToken()
+//
+//   creds := restapi.ChainCredentials(restapi.EnvCredentials("POWERWALL"),
+//                                     restapi.NetrcCredentials(""))
+//   r.AddAuthenticator(restapi.CredentialAuth(creds))
+//
+// Secret files (and .netrc) must not be readable by group or other
+//
+//
+
+package restapi
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var ErrNoCredentials = errors.New("restapi: no credentials found")
+var ErrInsecureFile  = errors.New("restapi: credential file is readable by others")
+
+type Credentials struct {
+  Username string
+  Password string
+  Token    string // sent as a bearer token
+  ApiKey   string // sent as x-api-key
+}
+
+func (c *Credentials) empty() bool {
+  return c.Username == "" && c.Password == "" && c.Token == "" && c.ApiKey == ""
+}
+
+//
+// A CredentialProvider looks up the credentials for a host ("host" or
+// "host:port").  ErrNoCredentials when it has none
+//
+
+type CredentialProvider interface {
+  Credentials(host string) (*Credentials, error)
+}
+
+//
+// CredentialProviderFunc lets a plain function be used as a provider
+//
+
+type CredentialProviderFunc func(host string) (*Credentials, error)
+
+func (f CredentialProviderFunc) Credentials(host string) (*Credentials, error) {
+  return f(host)
+}
+
+//
+// func EnvCredentials(prefix string) CredentialProvider
+//
+// Reads <prefix>_USERNAME, <prefix>_PASSWORD, <prefix>_TOKEN and
+// <prefix>_API_KEY, the same for every host
+//
+
+func EnvCredentials(prefix string) CredentialProvider {
+
+  return CredentialProviderFunc(func(host string) (*Credentials, error) {
+
+    c := &Credentials{ Username: os.Getenv(prefix + "_USERNAME"),
+                       Password: os.Getenv(prefix + "_PASSWORD"),
+                       Token: os.Getenv(prefix + "_TOKEN"),
+                       ApiKey: os.Getenv(prefix + "_API_KEY") }
+
+    if(c.empty()){
+      return nil, fmt.Errorf("%w: %s_* not set", ErrNoCredentials, prefix)
+    }
+
+    return c, nil
+  })
+}
+
+//
+// func FileCredentials(file string) CredentialProvider
+//
+// Reads file on each request so rotated secrets are picked up.  Either
+// a bare token, or key=value lines:
+//
+//   username=admin
+//   password=secret
+//   token=...
+//   api_key=...
+//
+// Blank lines and # comments are skipped
+//
+
+func FileCredentials(file string) CredentialProvider {
+
+  return CredentialProviderFunc(func(host string) (*Credentials, error) {
+
+    data, err := readSecretFile(file)
+
+    if(err != nil){
+      return nil, err
+    }
+
+    content := strings.TrimSpace(string(data))
+
+    if(!strings.Contains(content, "=")){
+      if(content == ""){
+        return nil, fmt.Errorf("%w: %s is empty", ErrNoCredentials, file)
+      }
+      return &Credentials{ Token: content }, nil
+    }
+
+    c := new(Credentials)
+
+    for _, line := range strings.Split(content, "\n") {
+
+      line = strings.TrimSpace(line)
+
+      if(line == "" || strings.HasPrefix(line, "#")){
+        continue
+      }
+
+      key, value, _ := strings.Cut(line, "=")
+      value = strings.TrimSpace(value)
+
+      switch strings.ToLower(strings.TrimSpace(key)) {
+        case "username", "user", "login":
+          c.Username = value
+        case "password":
+          c.Password = value
+        case "token":
+          c.Token = value
+        case "api_key", "apikey":
+          c.ApiKey = value
+      }
+    }
+
+    if(c.empty()){
+      return nil, fmt.Errorf("%w: nothing usable in %s", ErrNoCredentials, file)
+    }
+
+    return c, nil
+  })
+}
+
+//
+// func NetrcCredentials(file string) CredentialProvider
+//
+// login/password for the host from a .netrc file, falling back to its
+// default entry.  file "" means $NETRC or ~/.netrc, and not having one
+// is ErrNoCredentials - a file named here has to exist
+//
+
+func NetrcCredentials(file string) CredentialProvider {
+
+  return CredentialProviderFunc(func(host string) (*Credentials, error) {
+
+    path := file
+
+    if(path == ""){
+      path = os.Getenv("NETRC")
+    }
+
+    if(path == ""){
+      home, err := os.UserHomeDir()
+
+      if(err != nil){
+        return nil, err
+      }
+
+      path = filepath.Join(home, ".netrc")
+    }
+
+    data, err := readSecretFile(path)
+
+    if(file == "" && errors.Is(err, os.ErrNotExist)){
+      return nil, fmt.Errorf("%w: no %s", ErrNoCredentials, path)
+    }
+
+    if(err != nil){
+      return nil, err
+    }
+
+    name := host
+
+    if h, _, err := net.SplitHostPort(host); err == nil {
+      name = h
+    }
+
+    c := parseNetrc(string(data), name)
+
+    if(c == nil){
+      return nil, fmt.Errorf("%w: no %s entry in %s", ErrNoCredentials, name, path)
+    }
+
+    return c, nil
+  })
+}
+
+//
+// func parseNetrc(data string, host string) *Credentials
+//
+// The machine entry for host, else the default entry, else nil.  A
+// keyword's value may be on the next line
+//
+
+func parseNetrc(data string, host string) *Credentials {
+
+  var found, fallback *Credentials
+  var cur *Credentials
+
+  scanner := bufio.NewScanner(strings.NewReader(data))
+
+  inMacro := false
+
+  key := "" // keyword still waiting for its value
+
+  for scanner.Scan() {
+
+    line := scanner.Text()
+
+    // a macdef runs to the next blank line
+
+    if(inMacro){
+      inMacro = strings.TrimSpace(line) != ""
+      continue
+    }
+
+    for _, tok := range netrcFields(line) {
+
+      if(key != ""){
+
+        switch key {
+          case "machine":
+            if(found == nil && strings.EqualFold(tok, host)){
+              found = new(Credentials)
+              cur = found
+            }
+          case "login":
+            if(cur != nil){
+              cur.Username = tok
+            }
+          case "password":
+            if(cur != nil){
+              cur.Password = tok
+            }
+        }
+
+        key = ""
+        continue
+      }
+
+      switch tok {
+        case "machine":
+          cur = nil
+          key = tok
+        case "default":
+          cur = nil
+          if(fallback == nil){
+            fallback = new(Credentials)
+            cur = fallback
+          }
+        case "login", "password", "account":
+          key = tok
+        case "macdef":
+          cur = nil
+          inMacro = true
+      }
+
+      if(inMacro){
+        break // the rest of the line is the macro name
+      }
+    }
+  }
+
+  if(found != nil){
+    return found
+  }
+
+  return fallback
+}
+
+//
+// func netrcFields(line string) []string
+//
+// The tokens on a .netrc line.  A token in double quotes may hold
+// spaces, with \" and \\ for a quote or backslash
+//
+
+func netrcFields(line string) []string {
+
+  var fields []string
+
+  for {
+
+    line = strings.TrimLeft(line, " \t\r")
+
+    if(line == ""){
+      return fields
+    }
+
+    if(line[0] != '"'){
+      end := strings.IndexAny(line, " \t\r")
+
+      if(end < 0){
+        end = len(line)
+      }
+
+      fields = append(fields, line[:end])
+      line = line[end:]
+      continue
+    }
+
+    var b strings.Builder
+
+    i := 1
+
+    for ; i < len(line) && line[i] != '"'; i++ {
+      if(line[i] == '\\' && i+1 < len(line)){
+        i++
+      }
+      b.WriteByte(line[i])
+    }
+
+    fields = append(fields, b.String())
+    line = line[min(i + 1, len(line)):]
+  }
+}
+
+//
+// func ChainCredentials(providers ...CredentialProvider) CredentialProvider
+//
+// The first provider that has credentials for the host wins.  Errors
+// other than ErrNoCredentials (bad permissions etc) stop the search
+//
+
+func ChainCredentials(providers ...CredentialProvider) CredentialProvider {
+
+  return CredentialProviderFunc(func(host string) (*Credentials, error) {
+
+    for _, p := range providers {
+
+      c, err := p.Credentials(host)
+
+      if(err == nil){
+        return c, nil
+      }
+
+      if(!errors.Is(err, ErrNoCredentials) && !errors.Is(err, os.ErrNotExist)){
+        return nil, err
+      }
+    }
+
+    return nil, fmt.Errorf("%w for %s", ErrNoCredentials, host)
+  })
+}
+
+//
+// func CredentialAuth(p CredentialProvider) Authenticator
+//
+// Authenticates with whatever p has for the request's host - Token as
+// a bearer token, otherwise Username/Password as basic auth, plus
+// ApiKey as x-api-key.  Nothing is added when p has no credentials,
+// but a file it can't read (missing, bad permissions) fails the request
+//
+
+func CredentialAuth(p CredentialProvider) Authenticator {
+
+  return AuthenticatorFunc(func(req *http.Request) error {
+
+    c, err := p.Credentials(req.URL.Host)
+
+    if(errors.Is(err, ErrNoCredentials)){
+      return nil
+    }
+
+    if(err != nil){
+      return err
+    }
+
+    if(c.Token != ""){
+      req.Header.Set("Authorization", "Bearer " + c.Token)
+    }else if(c.Username != "" || c.Password != ""){
+      req.SetBasicAuth(c.Username, c.Password)
+    }
+
+    if(c.ApiKey != ""){
+      req.Header.Set("x-api-key", c.ApiKey)
+    }
+
+    return nil
+  })
+}
+
+//
+// func readSecretFile(file string) ([]byte, error)
+//
+// Reads file, refusing it if group or other can read it (not checked
+// on windows)
+//
+
+func readSecretFile(file string) ([]byte, error) {
+
+  info, err := os.Stat(file)
+
+  if(err != nil){
+    return nil, err
+  }
+
+  if(runtime.GOOS != "windows" && info.Mode().Perm() & 0077 != 0){
+    return nil, fmt.Errorf("%w: %s has mode %04o, want 0600", ErrInsecureFile, file, uint32(info.Mode().Perm()))
+  }
+
+  return os.ReadFile(file)
+}
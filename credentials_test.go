@@ -0,0 +1,257 @@
+package restapi
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func writeSecret(t *testing.T, name string, data string, mode os.FileMode) string {
+
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	if err := os.WriteFile(path, []byte(data), mode); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(path, mode); err != nil { // past the umask
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestParseNetrc(t *testing.T) {
+
+	netrc := `default login anon password guest
+
+machine other.example login o password op
+machine API.example.com
+  login alice
+  password "p w \"quoted\" \\ end"
+machine api.example.com login second password ignored
+
+macdef init
+machine macro.example login evil password evil
+
+machine macro.example login real password realpw
+machine split.example login
+  bob password
+  bobpw
+`
+
+	tests := []struct {
+		host string
+		user string
+		pass string
+	}{
+		{"api.example.com", "alice", `p w "quoted" \ end`}, // case insensitive, first entry wins
+		{"other.example", "o", "op"},
+		{"macro.example", "real", "realpw"},  // the macdef body is not an entry
+		{"split.example", "bob", "bobpw"},    // values on the next line
+		{"unknown.example", "anon", "guest"}, // default, even though it came first
+	}
+
+	for _, tt := range tests {
+
+		c := parseNetrc(netrc, tt.host)
+
+		if c == nil || c.Username != tt.user || c.Password != tt.pass {
+			t.Errorf("%s: got %+v, want %s / %s", tt.host, c, tt.user, tt.pass)
+		}
+	}
+
+	if c := parseNetrc("machine a login x password y\n", "b"); c != nil {
+		t.Errorf("no default entry - got %+v", c)
+	}
+}
+
+func TestNetrcFields(t *testing.T) {
+
+	got := netrcFields(`  machine h	password "a b" login "unterminated x`)
+	want := []string{"machine", "h", "password", "a b", "login", "unterminated x"}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("field %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNetrcCredentials(t *testing.T) {
+
+	path := writeSecret(t, "netrc", "machine api.example.com login alice password pw\n", 0600)
+
+	c, err := NetrcCredentials(path).Credentials("api.example.com:8443")
+
+	if err != nil || c.Username != "alice" || c.Password != "pw" {
+		t.Fatalf("got %+v, %v", c, err)
+	}
+
+	if _, err := NetrcCredentials(path).Credentials("other.example"); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("unknown host: err = %v", err)
+	}
+
+	// no ~/.netrc is just no credentials, a named file has to be there
+
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "none"))
+
+	if _, err := NetrcCredentials("").Credentials("api.example.com"); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("missing default netrc: err = %v", err)
+	}
+
+	if _, err := NetrcCredentials(path + ".typo").Credentials("api.example.com"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing named netrc: err = %v", err)
+	}
+}
+
+func TestFileCredentialsFormats(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data string
+		want Credentials
+	}{
+		{"token", "  tok-123\n", Credentials{Token: "tok-123"}},
+		{"keys", "# comment\n\nuser = admin\npassword=a=b\napikey=k\n", Credentials{Username: "admin", Password: "a=b", ApiKey: "k"}},
+		{"token key", "token=t\nlogin=l\n", Credentials{Token: "t", Username: "l"}},
+	}
+
+	for _, tt := range tests {
+
+		c, err := FileCredentials(writeSecret(t, tt.name, tt.data, 0600)).Credentials("h")
+
+		if err != nil || *c != tt.want {
+			t.Errorf("%s: got %+v, %v - want %+v", tt.name, c, err, tt.want)
+		}
+	}
+
+	for _, data := range []string{"", "  \n", "colour=blue\n"} {
+		if _, err := FileCredentials(writeSecret(t, "empty", data, 0600)).Credentials("h"); !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("%q: err = %v, want ErrNoCredentials", data, err)
+		}
+	}
+}
+
+func TestSecretFilePermissions(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("modes are not checked on windows")
+	}
+
+	for _, mode := range []os.FileMode{0644, 0640, 0604, 0660} {
+
+		path := writeSecret(t, "secret", "token", mode)
+
+		if _, err := FileCredentials(path).Credentials("h"); !errors.Is(err, ErrInsecureFile) {
+			t.Errorf("mode %04o: err = %v, want ErrInsecureFile", mode, err)
+		}
+
+		if _, err := NetrcCredentials(path).Credentials("h"); !errors.Is(err, ErrInsecureFile) {
+			t.Errorf("netrc mode %04o: err = %v, want ErrInsecureFile", mode, err)
+		}
+	}
+
+	if _, err := FileCredentials(writeSecret(t, "secret", "token", 0400)).Credentials("h"); err != nil {
+		t.Errorf("mode 0400: %v", err)
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+
+	t.Setenv("RTEST_USERNAME", "u")
+	t.Setenv("RTEST_PASSWORD", "p")
+	t.Setenv("RTEST_TOKEN", "")
+	t.Setenv("RTEST_API_KEY", "k")
+
+	c, err := EnvCredentials("RTEST").Credentials("h")
+
+	if err != nil || *c != (Credentials{Username: "u", Password: "p", ApiKey: "k"}) {
+		t.Errorf("got %+v, %v", c, err)
+	}
+
+	if _, err := EnvCredentials("RTEST_UNSET").Credentials("h"); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("unset: err = %v", err)
+	}
+}
+
+func TestChainCredentialsOrder(t *testing.T) {
+
+	dir := t.TempDir()
+
+	netrc := writeSecret(t, "netrc", "machine h login fromnetrc password pw\n", 0600)
+	file := writeSecret(t, "token", "fromfile", 0600)
+
+	empty := EnvCredentials("RTEST_UNSET")
+	missing := FileCredentials(filepath.Join(dir, "missing"))
+
+	// no credentials and missing files fall through, first hit wins
+
+	c, err := ChainCredentials(empty, missing, NetrcCredentials(netrc), FileCredentials(file)).Credentials("h")
+
+	if err != nil || c.Username != "fromnetrc" {
+		t.Errorf("got %+v, %v - want the netrc entry", c, err)
+	}
+
+	c, err = ChainCredentials(empty, NetrcCredentials(netrc), FileCredentials(file)).Credentials("other")
+
+	if err != nil || c.Token != "fromfile" {
+		t.Errorf("got %+v, %v - want the file token", c, err)
+	}
+
+	// anything else stops the search
+
+	if runtime.GOOS != "windows" {
+
+		open := writeSecret(t, "open", "token", 0644)
+
+		if _, err := ChainCredentials(FileCredentials(open), FileCredentials(file)).Credentials("h"); !errors.Is(err, ErrInsecureFile) {
+			t.Errorf("insecure file: err = %v", err)
+		}
+	}
+
+	if _, err := ChainCredentials(empty, missing).Credentials("h"); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("nothing anywhere: err = %v", err)
+	}
+}
+
+func TestCredentialAuth(t *testing.T) {
+
+	auth := func(p CredentialProvider) (*http.Request, error) {
+		req, _ := http.NewRequest("GET", "https://api.example.com/x", nil)
+		return req, CredentialAuth(p).Authenticate(req)
+	}
+
+	req, err := auth(FileCredentials(writeSecret(t, "token", "tok", 0600)))
+
+	if err != nil || req.Header.Get("Authorization") != "Bearer tok" {
+		t.Errorf("token: %v %v", req.Header, err)
+	}
+
+	req, err = auth(FileCredentials(writeSecret(t, "basic", "user=u\npassword=p\napi_key=k\n", 0600)))
+
+	if user, pass, ok := req.BasicAuth(); err != nil || !ok || user != "u" || pass != "p" || req.Header.Get("X-Api-Key") != "k" {
+		t.Errorf("basic: %v %v", req.Header, err)
+	}
+
+	req, err = auth(EnvCredentials("RTEST_UNSET"))
+
+	if err != nil || len(req.Header) != 0 {
+		t.Errorf("no credentials: %v %v", req.Header, err)
+	}
+
+	// a mistyped path is an error, not an unauthenticated request
+
+	if _, err := auth(FileCredentials(filepath.Join(t.TempDir(), "typo"))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+//
+//
+// restapi - typed decoding
+//
+// Unmarshals the response into your own types - e.g. the structs
+// SaveResponseBody() generates - rather than walking RawData with
+// GetValue() and the Cast helpers
+//
+//   vehicles, err := restapi.SendInto[VehicleList](r)
+//
+//   r.Send()
+//   var v VehicleList
+//   err := r.DecodeInto(&v)
+//
+// SetUseNumber(true) keeps numbers landing in interface{} values as
+// json.Number so large ids (the Tesla id field) don't lose precision
+// going through float64
+//
+//
+
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+)
+
+//
+// func (pRA *Restapi) SetStrictDecode(strict bool)
+//
+// Strict DecodeInto()/SendInto() fail on fields the target type doesn't
+// have, and on anything after the json value
+//
+
+func (pRA *Restapi) SetStrictDecode(strict bool){
+  pRA.bStrictDecode = strict
+}
+
+//
+// func (pRA *Restapi) SetUseNumber(use bool)
+//
+// DecodeInto()/SendInto() put numbers into interface{} values as
+// json.Number instead of float64
+//
+
+func (pRA *Restapi) SetUseNumber(use bool){
+  pRA.bUseNumber = use
+}
+
+//
+// func (pRA *Restapi) DecodeInto(v any) error
+//
+// Unmarshals the last response body into v (a pointer).  XML requests
+// use encoding/xml.  An empty body (204 etc) leaves v alone
+//
+
+func (pRA *Restapi) DecodeInto(v any) error {
+
+  body := pRA.BodyBytes
+
+  if(len(bytes.TrimSpace(body)) == 0){
+    return nil
+  }
+
+  if(pRA.bXML){
+    if err := xml.Unmarshal(body, v); err != nil {
+      return &DecodeError{ Name: pRA.sName, Format: "xml", Err: err, Body: body }
+    }
+    return nil
+  }
+
+  return pRA.decodeJSON(body, v, "json")
+}
+
+//
+// func (pRA *Restapi) decodeJSON(data []byte, v any, format string) error
+//
+// Unmarshals one json value with our strict/number settings
+//
+
+func (pRA *Restapi) decodeJSON(data []byte, v any, format string) error {
+
+  dec := json.NewDecoder(bytes.NewReader(data))
+
+  if(pRA.bStrictDecode){
+    dec.DisallowUnknownFields()
+  }
+
+  if(pRA.bUseNumber){
+    dec.UseNumber()
+  }
+
+  err := dec.Decode(v)
+
+  // More() is false on a stray } or ] - only the end of the data will do
+
+  if(err == nil && pRA.bStrictDecode){
+    if _, terr := dec.Token(); terr != io.EOF {
+      err = errors.New("unexpected data after the json value")
+    }
+  }
+
+  if(err != nil){
+    return &DecodeError{ Name: pRA.sName, Format: format, Err: err, Body: data }
+  }
+
+  return nil
+}
+
+//
+// func SendInto[T any](r *Restapi) (T, error)
+//
+// Sends r and decodes the response into a T.  RawData and the response
+// maps are not built
+//
+
+func SendInto[T any](r *Restapi) (T, error) {
+  return SendIntoContext[T](context.Background(), r)
+}
+
+//
+// func SendIntoContext[T any](ctx context.Context, r *Restapi) (T, error)
+//
+// SendInto() with a context
+//
+
+func SendIntoContext[T any](ctx context.Context, r *Restapi) (T, error) {
+
+  var v T
+
+  r.bDecodeOnly = true
+  defer func() { r.bDecodeOnly = false }()
+
+  if _, err := r.DoContext(ctx); err != nil {
+    return v, err
+  }
+
+  if err := r.DecodeInto(&v); err != nil {
+    var zero T
+    return zero, err
+  }
+
+  return v, nil
+}
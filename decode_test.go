@@ -0,0 +1,55 @@
+package restapi
+
+import (
+	"testing"
+)
+
+func TestStrictDecodeTrailingData(t *testing.T) {
+
+	type item struct {
+		A int `json:"a"`
+	}
+
+	tests := []struct {
+		body string
+		ok   bool
+	}{
+		{`{"a":1}`, true},
+		{" {\"a\":1} \n\t", true},
+		{`{"a":1}}`, false},
+		{`{"a":1}]`, false},
+		{`{"a":1} {"a":2}`, false},
+		{`{"a":1} x`, false},
+		{`{"a":1,"b":2}`, false}, // unknown field
+	}
+
+	for _, tt := range tests {
+
+		r := NewGet("strict", "http://unused")
+		r.SetStrictDecode(true)
+
+		var v item
+
+		err := r.decodeJSON([]byte(tt.body), &v, "json")
+
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: err = %v, want ok %t", tt.body, err, tt.ok)
+		}
+
+		if err != nil {
+			if _, isDecode := err.(*DecodeError); !isDecode {
+				t.Errorf("%q: %T is not a DecodeError", tt.body, err)
+			}
+		}
+	}
+
+	// not strict - trailing data is ignored as before
+
+	r := NewGet("lenient", "http://unused")
+
+	var v item
+
+	if err := r.decodeJSON([]byte(`{"a":1}}`), &v, "json"); err != nil || v.A != 1 {
+		t.Errorf("lenient: %+v %v", v, err)
+	}
+}
@@ -0,0 +1,221 @@
+package restapi
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// RFC 7616 section 3.9.1
+
+const (
+	rfc7616Realm  = "http-auth@example.org"
+	rfc7616Nonce  = "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"
+	rfc7616Opaque = "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"
+	rfc7616Cnonce = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+)
+
+func rfc7616Challenge(algorithm string) string {
+	return `Digest realm="` + rfc7616Realm + `", qop="auth, auth-int", algorithm=` + algorithm +
+		`, nonce="` + rfc7616Nonce + `", opaque="` + rfc7616Opaque + `"`
+}
+
+func fixedCnonce(t *testing.T, cnonce string) {
+
+	saved := digestCnonce
+	digestCnonce = func() string { return cnonce }
+
+	t.Cleanup(func() { digestCnonce = saved })
+}
+
+func TestDigestRFC7616(t *testing.T) {
+
+	fixedCnonce(t, rfc7616Cnonce)
+
+	tests := []struct {
+		name       string
+		challenges []string
+		response   string
+		algorithm  string
+	}{
+		{"SHA-256", []string{rfc7616Challenge("SHA-256")},
+			"753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1", "SHA-256"},
+		{"MD5", []string{rfc7616Challenge("MD5")},
+			"8ca523f5e9506fed4657c9700eebdbec", "MD5"},
+		// the RFC's server offers both - the stronger one wins
+		{"both", []string{rfc7616Challenge("MD5"), rfc7616Challenge("SHA-256")},
+			"753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1", "SHA-256"},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			d := DigestAuth("Mufasa", "Circle of Life")
+
+			resp := &Response{Header: http.Header{"Www-Authenticate": tt.challenges}}
+
+			if !d.Challenge(resp) {
+				t.Fatal("Challenge() = false")
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, "http://www.example.org/dir/index.html", nil)
+
+			if err := d.Authenticate(req); err != nil {
+				t.Fatal(err)
+			}
+
+			header := req.Header.Get("Authorization")
+
+			if !strings.HasPrefix(header, "Digest ") {
+				t.Fatalf("Authorization = %s", header)
+			}
+
+			params := parseAuthParams(strings.TrimPrefix(header, "Digest "))
+
+			want := map[string]string{
+				"username":  "Mufasa",
+				"realm":     rfc7616Realm,
+				"uri":       "/dir/index.html",
+				"algorithm": tt.algorithm,
+				"nonce":     rfc7616Nonce,
+				"nc":        "00000001",
+				"cnonce":    rfc7616Cnonce,
+				"qop":       "auth",
+				"response":  tt.response,
+				"opaque":    rfc7616Opaque,
+			}
+
+			for k, v := range want {
+				if params[k] != v {
+					t.Errorf("%s = %q, want %q", k, params[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestDigestNonceCount(t *testing.T) {
+
+	d := DigestAuth("Mufasa", "Circle of Life")
+	d.Challenge(&Response{Header: http.Header{"Www-Authenticate": {rfc7616Challenge("SHA-256")}}})
+
+	for _, want := range []string{"00000001", "00000002", "00000003"} {
+
+		req, _ := http.NewRequest(http.MethodGet, "http://www.example.org/", nil)
+		d.Authenticate(req)
+
+		params := parseAuthParams(strings.TrimPrefix(req.Header.Get("Authorization"), "Digest "))
+
+		if params["nc"] != want {
+			t.Fatalf("nc = %s, want %s", params["nc"], want)
+		}
+	}
+}
+
+func TestDigestStaleAndWrongPassword(t *testing.T) {
+
+	d := DigestAuth("Mufasa", "Circle of Life")
+
+	challenge := &Response{Header: http.Header{"Www-Authenticate": {rfc7616Challenge("MD5")}}}
+
+	if !d.Challenge(challenge) {
+		t.Fatal("first challenge refused")
+	}
+
+	// same nonce again without stale=true - the password was wrong
+
+	if d.Challenge(challenge) {
+		t.Fatal("repeat challenge accepted")
+	}
+
+	stale := &Response{Header: http.Header{"Www-Authenticate": {rfc7616Challenge("MD5") + ", stale=true"}}}
+
+	if !d.Challenge(stale) {
+		t.Fatal("stale challenge refused")
+	}
+}
+
+//
+// A server checking the answer the way RFC 7616 says, MD5 qop=auth
+//
+
+func digestServer(hits *atomic.Int32) *httptest.Server {
+
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		hits.Add(1)
+
+		params := parseAuthParams(strings.TrimPrefix(r.Header.Get("Authorization"), "Digest "))
+
+		ha1 := md5hex("Mufasa:" + rfc7616Realm + ":Circle of Life")
+		ha2 := md5hex(r.Method + ":" + r.URL.RequestURI())
+		want := md5hex(ha1 + ":" + rfc7616Nonce + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+
+		if params["response"] != want || params["uri"] != r.URL.RequestURI() {
+			w.Header().Set("WWW-Authenticate", rfc7616Challenge("MD5"))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`{"ok":true}`))
+	}))
+}
+
+func TestDigestRoundTrip(t *testing.T) {
+
+	var hits atomic.Int32
+
+	srv := digestServer(&hits)
+	defer srv.Close()
+
+	r := NewGet("digest", srv.URL+"/dir/index.html?x=1")
+	r.AddAuthenticator(DigestAuth("Mufasa", "Circle of Life"))
+
+	if _, err := r.Do(); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	if hits.Load() != 2 {
+		t.Fatalf("hits = %d, want 2 (challenge then answer)", hits.Load())
+	}
+
+	// the nonce is reused with the next nc - no second challenge
+
+	if _, err := r.Do(); err != nil || hits.Load() != 3 {
+		t.Fatalf("second Do: %v, hits = %d", err, hits.Load())
+	}
+}
+
+func TestDigestWrongPassword(t *testing.T) {
+
+	var hits atomic.Int32
+
+	srv := digestServer(&hits)
+	defer srv.Close()
+
+	r := NewGet("digest", srv.URL)
+	r.AddAuthenticator(DigestAuth("Mufasa", "wrong"))
+
+	_, err := r.Do()
+
+	var statusErr *StatusError
+
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("err = %v, want a 401 StatusError", err)
+	}
+
+	if hits.Load() != 2 {
+		t.Fatalf("hits = %d, want 2", hits.Load())
+	}
+}
@@ -0,0 +1,159 @@
+//
+//
+// restapi - errors
+//
+// Typed errors returned by Do()/DoContext().  Each type matches one of
+// the sentinel errors below with errors.Is, and can be pulled out with
+// errors.As when the caller needs the details (status code, body, etc)
+//
+// Send() still just returns true/false and logs the error
+//
+//
+
+package restapi
+
+import (
+	"fmt"
+	"errors"
+	"net/http"
+)
+
+var (
+  ErrNoUrl        = errors.New("restapi: url not set")
+  ErrMethod       = errors.New("restapi: unknown http method")
+  ErrTransport    = errors.New("restapi: transport error")
+  ErrStatus       = errors.New("restapi: unexpected http status")
+  ErrDecode       = errors.New("restapi: unable to decode response")
+  ErrCertLoad     = errors.New("restapi: unable to load certificate")
+  ErrAuth         = errors.New("restapi: unable to authenticate")
+  ErrBodyTooLarge = errors.New("restapi: response body too large")
+)
+
+//
+// TransportError
+//
+// The request never got a usable response - DNS failure, connection
+// refused, TLS verification failure, timeout, cancelled context, etc.
+// The underlying error is available via errors.As (*net.DNSError,
+// *tls.CertificateVerificationError, context.DeadlineExceeded, ...)
+//
+
+type TransportError struct {
+  Name   string
+  Method string
+  Url    string // credentials masked, see SetShowSecrets()
+  Err    error
+}
+
+func (e *TransportError) Error() string {
+  return fmt.Sprintf("restapi %s: %s %s: %s", e.Name, e.Method, e.Url, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+  return e.Err
+}
+
+func (e *TransportError) Is(target error) bool {
+  return target == ErrTransport
+}
+
+//
+// StatusError
+//
+// The server answered, but with a status code we were not expecting.
+// The body and headers are kept so error payloads can be inspected
+//
+
+type StatusError struct {
+  Name       string
+  Method     string
+  Url        string // credentials masked, see SetShowSecrets()
+  StatusCode int
+  Status     string
+  Header     http.Header
+  Body       []byte
+}
+
+func (e *StatusError) Error() string {
+  return fmt.Sprintf("restapi %s: %s %s: HTTP Response Status: %d %s",
+                     e.Name, e.Method, e.Url, e.StatusCode,
+                     http.StatusText(e.StatusCode))
+}
+
+func (e *StatusError) Is(target error) bool {
+  return target == ErrStatus
+}
+
+//
+// DecodeError
+//
+// The response arrived but could not be turned into the data we were
+// asked for (XML conversion, inner map missing, wrong json shape)
+//
+
+type DecodeError struct {
+  Name   string
+  Format string // "json" or "xml"
+  Err    error
+  Body   []byte
+}
+
+func (e *DecodeError) Error() string {
+  return fmt.Sprintf("restapi %s: decoding %s response: %s", e.Name, e.Format, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+  return e.Err
+}
+
+func (e *DecodeError) Is(target error) bool {
+  return target == ErrDecode
+}
+
+//
+// CertError
+//
+// A certificate file given to UseCert could not be read or did not
+// contain any usable certificates
+//
+
+type CertError struct {
+  File string
+  Err  error
+}
+
+func (e *CertError) Error() string {
+  return fmt.Sprintf("restapi: certificate file [%s]: %s", e.File, e.Err)
+}
+
+func (e *CertError) Unwrap() error {
+  return e.Err
+}
+
+func (e *CertError) Is(target error) bool {
+  return target == ErrCertLoad
+}
+
+//
+// AuthError
+//
+// Credentials for the request could not be obtained - e.g. the oauth2
+// token endpoint refused the refresh token
+//
+
+type AuthError struct {
+  Name string
+  Err  error
+}
+
+func (e *AuthError) Error() string {
+  return fmt.Sprintf("restapi %s: authentication: %s", e.Name, e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+  return e.Err
+}
+
+func (e *AuthError) Is(target error) bool {
+  return target == ErrAuth
+}
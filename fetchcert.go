@@ -0,0 +1,251 @@
+//
+//
+// restapi - fetching a server's certificate chain
+//
+// FetchTLSChain() connects (without verifying) and returns whatever
+// certificates the server presents along with their fingerprints and
+// expiry - for saving with SaveCertPEM() and pinning later
+//
+//
+
+package restapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type FetchOptions struct {
+  Port       string        // used when the target has no port (default 443)
+  ServerName string        // SNI to send, default is the target's host
+  Timeout    time.Duration // connect + handshake (default 10 seconds)
+}
+
+type CertInfo struct {
+  Subject     string
+  Issuer      string
+  DNSNames    []string
+  NotBefore   time.Time
+  NotAfter    time.Time
+  Fingerprint string // sha256 of the certificate, AB:CD:... like openssl
+  SPKIHash    []byte // sha256 of the public key, see PinSPKIHash()
+}
+
+type PeerChain struct {
+  Addr         string              // host:port we connected to
+  ServerName   string              // SNI sent
+  Certificates []*x509.Certificate // leaf first
+  Info         []CertInfo          // one per certificate
+}
+
+//
+// FetchCertError
+//
+// Could not get the certificates - Op says which step failed
+// ("parse", "connect", "handshake")
+//
+
+type FetchCertError struct {
+  Target string
+  Op     string
+  Err    error
+}
+
+func (e *FetchCertError) Error() string {
+  return fmt.Sprintf("restapi: fetching certificate from [%s]: %s: %s", e.Target, e.Op, e.Err)
+}
+
+func (e *FetchCertError) Unwrap() error {
+  return e.Err
+}
+
+func (e *FetchCertError) Is(target error) bool {
+  return target == ErrTransport
+}
+
+//
+// func FetchTLSChain(ctx context.Context, target string, opts *FetchOptions) (*PeerChain, error)
+//
+// Connects to target and returns the certificate chain it presents
+//
+// target - https://host:port/path, host:port or just host
+// opts - nil for the defaults
+//
+
+func FetchTLSChain(ctx context.Context, target string, opts *FetchOptions) (*PeerChain, error) {
+
+  if(opts == nil){
+    opts = &FetchOptions{}
+  }
+
+  port := opts.Port
+
+  if(port == ""){
+    port = "443"
+  }
+
+  timeout := opts.Timeout
+
+  if(timeout == 0){
+    timeout = 10 * time.Second
+  }
+
+  addr, host, err := tlsAddress(target, port)
+
+  if(err != nil){
+    return nil, &FetchCertError{ Target: target, Op: "parse", Err: err }
+  }
+
+  serverName := opts.ServerName
+
+  if(serverName == "" && net.ParseIP(host) == nil){
+    serverName = host
+  }
+
+  dialer := &tls.Dialer{ NetDialer: &net.Dialer{ Timeout: timeout },
+                         Config: &tls.Config{ InsecureSkipVerify: true,
+                                              ServerName: serverName } }
+
+  ctx, cancel := context.WithTimeout(ctx, timeout)
+  defer cancel()
+
+  conn, err := dialer.DialContext(ctx, "tcp", addr)
+
+  if(err != nil){
+    op := "connect"
+
+    var recErr tls.RecordHeaderError
+    var alert tls.AlertError
+
+    if(errors.As(err, &recErr) || errors.As(err, &alert)){
+      op = "handshake"
+    }
+
+    return nil, &FetchCertError{ Target: target, Op: op, Err: err }
+  }
+
+  defer conn.Close()
+
+  certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+
+  if(len(certs) == 0){
+    return nil, &FetchCertError{ Target: target, Op: "handshake",
+                                 Err: fmt.Errorf("server presented no certificates") }
+  }
+
+  chain := &PeerChain{ Addr: addr, ServerName: serverName, Certificates: certs }
+
+  for _, cert := range certs {
+    chain.Info = append(chain.Info, NewCertInfo(cert))
+  }
+
+  return chain, nil
+}
+
+//
+// func NewCertInfo(cert *x509.Certificate) CertInfo
+//
+// Summary of a certificate - names, expiry and fingerprints
+//
+
+func NewCertInfo(cert *x509.Certificate) CertInfo {
+
+  return CertInfo{ Subject: cert.Subject.String(),
+                   Issuer: cert.Issuer.String(),
+                   DNSNames: cert.DNSNames,
+                   NotBefore: cert.NotBefore,
+                   NotAfter: cert.NotAfter,
+                   Fingerprint: Fingerprint(cert),
+                   SPKIHash: SPKIHash(cert) }
+}
+
+//
+// func (ci CertInfo) Expired() bool
+//
+// Is the certificate past its NotAfter date
+//
+
+func (ci CertInfo) Expired() bool {
+  return time.Now().After(ci.NotAfter)
+}
+
+//
+// func (ci CertInfo) ExpiresIn() time.Duration
+//
+// Time left before the certificate expires (negative once it has)
+//
+
+func (ci CertInfo) ExpiresIn() time.Duration {
+  return time.Until(ci.NotAfter)
+}
+
+//
+// func Fingerprint(cert *x509.Certificate) string
+//
+// sha256 fingerprint as AB:CD:EF:... (same as openssl x509 -fingerprint -sha256)
+//
+
+func Fingerprint(cert *x509.Certificate) string {
+
+  sum := sha256.Sum256(cert.Raw)
+
+  parts := make([]string, len(sum))
+
+  for i, b := range sum {
+    parts[i] = fmt.Sprintf("%02X", b)
+  }
+
+  return strings.Join(parts, ":")
+}
+
+//
+// func tlsAddress(target string, port string) (string, string, error)
+//
+// Works out host:port (and the bare host) from a url, host:port or host
+//
+
+func tlsAddress(target string, port string) (string, string, error) {
+
+  target = strings.TrimSpace(target)
+
+  if(target == ""){
+    return "", "", fmt.Errorf("empty target")
+  }
+
+  if(strings.Contains(target, "://")){
+
+    u, err := url.Parse(target)
+
+    if(err != nil){
+      return "", "", err
+    }
+
+    if(u.Hostname() == ""){
+      return "", "", fmt.Errorf("no host in [%s]", target)
+    }
+
+    if(u.Port() != ""){
+      port = u.Port()
+    }
+
+    return net.JoinHostPort(u.Hostname(), port), u.Hostname(), nil
+  }
+
+  if host, p, err := net.SplitHostPort(target); err == nil {
+    return net.JoinHostPort(host, p), host, nil
+  }
+
+  // bare host (or bare ipv6 address, possibly in brackets)
+
+  host := strings.TrimSuffix(strings.TrimPrefix(target, "["), "]")
+
+  return net.JoinHostPort(host, port), host, nil
+}
@@ -6,6 +6,7 @@ require (
 	github.com/basgys/goxml2json v1.1.0
 	github.com/seldonsmule/logmsg v0.0.0-20241003160416-31459cf370a7
 	github.com/twpayne/go-jsonstruct v1.2.0
+	golang.org/x/crypto v0.36.0
 )
 
 require (
@@ -0,0 +1,160 @@
+//
+//
+// restapi - request headers
+//
+// Every request starts with its client's default headers (cache-control
+// and Content-Type: application/json out of the box), then any headers
+// set on the request itself replace the defaults of the same name
+//
+// The package level functions work on DefaultClient
+//
+//
+
+package restapi
+
+import (
+	"net/http"
+)
+
+//
+// func SetDefaultHeader(name string, value string)
+//
+// Sets a header sent on every DefaultClient request, replacing any
+// existing value
+//
+// name - header name
+// value - header value
+//
+
+func SetDefaultHeader(name string, value string){
+
+  DefaultClient.SetDefaultHeader(name, value)
+}
+
+//
+// func AddDefaultHeader(name string, value string)
+//
+// Adds a value to a header sent on every DefaultClient request
+//
+// name - header name
+// value - header value
+//
+
+func AddDefaultHeader(name string, value string){
+
+  DefaultClient.AddDefaultHeader(name, value)
+}
+
+//
+// func DelDefaultHeader(name string)
+//
+// Stops a header being sent on every DefaultClient request - including
+// the built in cache-control and Content-Type
+//
+// name - header name
+//
+
+func DelDefaultHeader(name string){
+
+  DefaultClient.DelDefaultHeader(name)
+}
+
+//
+// func GetDefaultHeaders() http.Header
+//
+// Returns a copy of the DefaultClient headers
+//
+
+func GetDefaultHeaders() http.Header{
+
+  return DefaultClient.GetDefaultHeaders()
+}
+
+//
+// func (pRA *Restapi) SetHeader(name string, value string)
+//
+// Sets a header for this request, replacing any default of that name
+//
+// name - header name (Accept, User-Agent, If-None-Match, ...)
+// value - header value
+//
+
+func (pRA *Restapi) SetHeader(name string, value string){
+
+  if(pRA.hHeaders == nil){
+    pRA.hHeaders = make(http.Header)
+  }
+
+  pRA.hHeaders.Set(name, value)
+  delete(pRA.mDelHeaders, http.CanonicalHeaderKey(name))
+}
+
+//
+// func (pRA *Restapi) AddHeader(name string, value string)
+//
+// Adds a value to a header for this request.  The values added here
+// still replace any default of the same name
+//
+// name - header name
+// value - header value
+//
+
+func (pRA *Restapi) AddHeader(name string, value string){
+
+  if(pRA.hHeaders == nil){
+    pRA.hHeaders = make(http.Header)
+  }
+
+  pRA.hHeaders.Add(name, value)
+  delete(pRA.mDelHeaders, http.CanonicalHeaderKey(name))
+}
+
+//
+// func (pRA *Restapi) DelHeader(name string)
+//
+// Removes a header from this request, whether it was set here or is
+// one of the defaults
+//
+// name - header name
+//
+
+func (pRA *Restapi) DelHeader(name string){
+
+  if(pRA.mDelHeaders == nil){
+    pRA.mDelHeaders = make(map[string]bool)
+  }
+
+  pRA.hHeaders.Del(name)
+  pRA.mDelHeaders[http.CanonicalHeaderKey(name)] = true
+}
+
+//
+// func (pRA *Restapi) GetHeader(name string) string
+//
+// Returns the value set on this request for name (defaults not included)
+//
+
+func (pRA *Restapi) GetHeader(name string) string{
+  return pRA.hHeaders.Get(name)
+}
+
+//
+// func (pRA *Restapi) applyHeaders(req *http.Request)
+//
+// Puts the client default then request headers onto req
+//
+
+func (pRA *Restapi) applyHeaders(req *http.Request){
+
+  for name, values := range pRA.pClient.GetDefaultHeaders() {
+    req.Header[name] = values
+  }
+
+  for name, values := range pRA.hHeaders {
+    req.Header[name] = append([]string(nil), values...)
+  }
+
+  for name := range pRA.mDelHeaders {
+    req.Header.Del(name)
+  }
+}
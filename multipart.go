@@ -0,0 +1,412 @@
+//
+//
+// restapi - multipart/form-data uploads
+//
+// Firmware images, photos, log bundles.  File parts are streamed from
+// disk (or an io.Reader) while the request is sent rather than being
+// read into memory first
+//
+//   m := restapi.NewMultipart()
+//   m.AddField("version", "1.4.2")
+//   m.AddFile("firmware", "/tmp/fw-1.4.2.bin")
+//   m.OnProgress(func(sent int64, total int64){ fmt.Println(sent, "/", total) })
+//
+//   r := restapi.NewPost("upload", "http://device.local/api/firmware")
+//   r.SetBodyMultipart(m)
+//
+// When every part's size is known (fields, files and sized readers)
+// Content-Length is sent - some embedded web servers won't take a
+// chunked upload
+//
+//
+
+package restapi
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//
+// Called as a body is sent or received.  total is -1 when not known
+//
+
+type ProgressFunc func(done int64, total int64)
+
+type Multipart struct {
+
+  aParts    []*multipartPart
+  sBoundary string
+  fProgress ProgressFunc
+
+}
+
+type multipartPart struct {
+  sField       string
+  sFileName    string    // "" for a plain field
+  sContentType string
+  sValue       string    // plain field
+  sPath        string    // file on disk - opened when sent
+  pReader      io.Reader // caller's reader - can only be sent once
+  nSize        int64     // -1 if not known
+}
+
+//
+// func NewMultipart() *Multipart
+//
+// An empty multipart/form-data body with a random boundary
+//
+
+func NewMultipart() *Multipart {
+  return &Multipart{ sBoundary: multipart.NewWriter(io.Discard).Boundary() }
+}
+
+//
+// func (m *Multipart) AddField(name string, value string)
+//
+// A plain text form field
+//
+
+func (m *Multipart) AddField(name string, value string){
+  m.aParts = append(m.aParts, &multipartPart{ sField: name, sValue: value, nSize: int64(len(value)) })
+}
+
+//
+// func (m *Multipart) AddFile(field string, path string) error
+//
+// A file part streamed from path.  The file name sent is the base name
+// of path and the Content-Type comes from its extension
+//
+
+func (m *Multipart) AddFile(field string, path string) error {
+
+  info, err := os.Stat(path)
+
+  if(err != nil){
+    return err
+  }
+
+  if(info.IsDir()){
+    return fmt.Errorf("%s is a directory", path)
+  }
+
+  name := filepath.Base(path)
+
+  m.aParts = append(m.aParts, &multipartPart{ sField: field,
+                                              sFileName: name,
+                                              sContentType: partContentType(name, ""),
+                                              sPath: path,
+                                              nSize: info.Size() })
+
+  return nil
+}
+
+//
+// func (m *Multipart) AddReader(field string, filename string, r io.Reader, size int64, contentType string)
+//
+// A file part read from r.  size -1 if not known (the upload is then
+// chunked).  contentType "" guesses from filename.  r can only be sent
+// once so the request won't be retried
+//
+
+func (m *Multipart) AddReader(field string, filename string, r io.Reader, size int64, contentType string){
+
+  m.aParts = append(m.aParts, &multipartPart{ sField: field,
+                                              sFileName: filename,
+                                              sContentType: partContentType(filename, contentType),
+                                              pReader: r,
+                                              nSize: size })
+}
+
+//
+// func (m *Multipart) SetBoundary(boundary string) error
+//
+// Use a fixed boundary - some devices are picky.  1-70 characters from
+// the RFC 2046 set
+//
+
+func (m *Multipart) SetBoundary(boundary string) error {
+
+  // let mime/multipart do the validation
+
+  if err := multipart.NewWriter(io.Discard).SetBoundary(boundary); err != nil {
+    return err
+  }
+
+  m.sBoundary = boundary
+
+  return nil
+}
+
+//
+// func (m *Multipart) Boundary() string
+//
+// The boundary between parts
+//
+
+func (m *Multipart) Boundary() string {
+  return m.sBoundary
+}
+
+//
+// func (m *Multipart) ContentType() string
+//
+// multipart/form-data; boundary=...
+//
+
+func (m *Multipart) ContentType() string {
+  return mime.FormatMediaType("multipart/form-data", map[string]string{ "boundary": m.sBoundary })
+}
+
+//
+// func (m *Multipart) OnProgress(fn ProgressFunc)
+//
+// fn is called as the body is sent
+//
+
+func (m *Multipart) OnProgress(fn ProgressFunc){
+  m.fProgress = fn
+}
+
+//
+// func (pRA *Restapi) SetBodyMultipart(m *Multipart)
+//
+// Sends m as the body with its multipart Content-Type
+//
+
+func (pRA *Restapi) SetBodyMultipart(m *Multipart){
+
+  pRA.clearBody()
+
+  pRA.pMultipart = m
+  pRA.sBodyType = m.ContentType()
+}
+
+//
+// func (m *Multipart) length() int64
+//
+// Size of the encoded body, -1 if a part's size isn't known
+//
+
+func (m *Multipart) length() int64 {
+
+  var cw countWriter
+
+  w := m.newWriter(&cw)
+
+  var total int64
+
+  for _, p := range m.aParts {
+
+    size := p.nSize
+
+    if(p.sPath != ""){
+      // the file may have changed since AddFile()
+      info, err := os.Stat(p.sPath)
+
+      if(err != nil){
+        return -1
+      }
+
+      size = info.Size()
+    }
+
+    if(size < 0){
+      return -1
+    }
+
+    if _, err := w.CreatePart(p.header()); err != nil {
+      return -1
+    }
+
+    total += size
+  }
+
+  w.Close()
+
+  return total + cw.n
+}
+
+//
+// func (m *Multipart) replayable() bool
+//
+// False if a part is a caller's reader - those can only be read once
+//
+
+func (m *Multipart) replayable() bool {
+
+  for _, p := range m.aParts {
+    if(p.pReader != nil){
+      return false
+    }
+  }
+
+  return true
+}
+
+//
+// func (m *Multipart) reader() io.ReadCloser
+//
+// The encoded body, written by a goroutine as it is read.  Closing it
+// (net/http does when the request ends) stops the goroutine
+//
+
+func (m *Multipart) reader() io.ReadCloser {
+
+  pr, pw := io.Pipe()
+
+  go func() {
+    pw.CloseWithError(m.writeTo(pw))
+  }()
+
+  if(m.fProgress == nil){
+    return pr
+  }
+
+  return &progressReader{ rc: pr, total: m.length(), fn: m.fProgress }
+}
+
+//
+// func (m *Multipart) writeTo(out io.Writer) error
+//
+// Encodes the parts, opening files as it gets to them
+//
+
+func (m *Multipart) writeTo(out io.Writer) error {
+
+  w := m.newWriter(out)
+
+  for _, p := range m.aParts {
+
+    part, err := w.CreatePart(p.header())
+
+    if(err != nil){
+      return err
+    }
+
+    switch {
+      case p.sPath != "":
+        err = copyFile(part, p.sPath)
+      case p.pReader != nil:
+        _, err = io.Copy(part, p.pReader)
+      default:
+        _, err = io.WriteString(part, p.sValue)
+    }
+
+    if(err != nil){
+      return err
+    }
+  }
+
+  return w.Close()
+}
+
+func (m *Multipart) newWriter(out io.Writer) *multipart.Writer {
+
+  w := multipart.NewWriter(out)
+  w.SetBoundary(m.sBoundary) // already checked
+
+  return w
+}
+
+//
+// func (p *multipartPart) header() textproto.MIMEHeader
+//
+// Content-Disposition (and Content-Type for files) for the part
+//
+
+func (p *multipartPart) header() textproto.MIMEHeader {
+
+  h := make(textproto.MIMEHeader)
+
+  disposition := fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(p.sField))
+
+  if(p.sFileName != ""){
+    disposition += fmt.Sprintf(`; filename="%s"`, escapeQuotes(p.sFileName))
+    h.Set("Content-Type", p.sContentType)
+  }
+
+  h.Set("Content-Disposition", disposition)
+
+  return h
+}
+
+func copyFile(out io.Writer, path string) error {
+
+  f, err := os.Open(path)
+
+  if(err != nil){
+    return err
+  }
+
+  defer f.Close()
+
+  _, err = io.Copy(out, f)
+
+  return err
+}
+
+//
+// func partContentType(filename string, contentType string) string
+//
+// contentType if set, else a guess from the extension
+//
+
+func partContentType(filename string, contentType string) string {
+
+  if(contentType != ""){
+    return contentType
+  }
+
+  if t := mime.TypeByExtension(filepath.Ext(filename)); t != "" {
+    return t
+  }
+
+  return "application/octet-stream"
+}
+
+func escapeQuotes(s string) string {
+  return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+type countWriter struct {
+  n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+  c.n += int64(len(p))
+  return len(p), nil
+}
+
+//
+// Counts bytes read through it for a ProgressFunc
+//
+
+type progressReader struct {
+  rc    io.ReadCloser
+  done  int64
+  total int64
+  fn    ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+
+  n, err := p.rc.Read(b)
+
+  if(n > 0){
+    p.done += int64(n)
+    p.fn(p.done, p.total)
+  }
+
+  return n, err
+}
+
+func (p *progressReader) Close() error {
+  return p.rc.Close()
+}
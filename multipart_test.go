@@ -0,0 +1,126 @@
+package restapi
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultipartUpload(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "fw.bin")
+	data := strings.Repeat("firmware", 4096)
+
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	var length int64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		length = r.ContentLength
+
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+		mr := multipart.NewReader(r.Body, params["boundary"])
+
+		for {
+			part, err := mr.NextPart()
+
+			if err != nil {
+				break
+			}
+
+			b, _ := io.ReadAll(part)
+
+			got[part.FormName()+"/"+part.FileName()] = string(b)
+		}
+
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	m := NewMultipart()
+	m.AddField("version", "1.4.2")
+
+	if err := m.AddFile("firmware", path); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent, total int64
+
+	m.OnProgress(func(done int64, all int64) { sent, total = done, all })
+
+	r := NewPost("upload", srv.URL)
+	r.JsonOnly()
+	r.SetBodyMultipart(m)
+
+	if _, err := r.Do(); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	if got["version/"] != "1.4.2" || got["firmware/fw.bin"] != data {
+		t.Fatalf("parts = %d fields, firmware %d bytes", len(got), len(got["firmware/fw.bin"]))
+	}
+
+	if length <= int64(len(data)) || sent != length || total != length {
+		t.Errorf("Content-Length %d, progress %d/%d", length, sent, total)
+	}
+}
+
+//
+// A request that fails before it is sent must not leave the multipart
+// writer goroutine (and its open file) behind
+//
+
+func TestMultipartNoLeakOnSignError(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "big.bin")
+
+	if err := os.WriteFile(path, make([]byte, 1<<20), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMultipart()
+
+	if err := m.AddFile("file", path); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewPost("upload", "http://127.0.0.1:1/")
+	r.SetBodyMultipart(m)
+	r.SetSigner(SignerFunc(func(req *http.Request, body []byte) error {
+		return errors.New("no key")
+	}))
+
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		if _, err := r.Do(); err == nil {
+			t.Fatal("Do worked")
+		}
+	}
+
+	// the goroutines see the closed pipe and exit
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for runtime.NumGoroutine() > before+2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := runtime.NumGoroutine(); n > before+2 {
+		t.Fatalf("goroutines %d -> %d", before, n)
+	}
+}
@@ -0,0 +1,123 @@
+//
+//
+// restapi - newline delimited json (NDJSON / JSON Lines)
+//
+// For log exports and watch style endpoints that send one json value
+// per line, possibly forever.  Each line is decoded as it arrives - the
+// body is never held in memory as a whole
+//
+//   for ev, err := range restapi.JSONLines[WatchEvent](ctx, r) {
+//     if(err != nil){
+//       return err
+//     }
+//     fmt.Println(ev.Type, ev.Object.Name)
+//   }
+//
+// Use map[string]interface{} as the type for untyped lines.
+// SetStrictDecode() and SetUseNumber() apply to every line, and
+// SetMaxBodySize() (16MB if not set) to the length of each one
+//
+//
+
+package restapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+)
+
+const ndjsonMaxLine = 16 * 1024 * 1024 // when SetMaxBodySize() isn't
+
+//
+// func JSONLines[T any](ctx context.Context, r *Restapi) iter.Seq2[T, error]
+//
+// Sends r and yields each line of the response decoded into a T.  Blank
+// lines are skipped.  An error (request, read or a line that won't
+// decode, one that never ends) is yielded once and ends the sequence.
+// Breaking out of the loop closes the connection
+//
+
+func JSONLines[T any](ctx context.Context, r *Restapi) iter.Seq2[T, error] {
+
+  return func(yield func(T, error) bool) {
+
+    var zero T
+
+    _, body, err := r.StreamContext(ctx)
+
+    if(err != nil){
+      yield(zero, err)
+      return
+    }
+
+    defer body.Close()
+
+    max := r.nMaxBodySize
+
+    if(max <= 0){
+      max = ndjsonMaxLine
+    }
+
+    // a server that never sends a newline can't make us buffer forever
+
+    scanner := bufio.NewScanner(body)
+    scanner.Buffer(make([]byte, 0, min(64 * 1024, max + 1)), int(max + 1))
+
+    for scanner.Scan() {
+
+      line := scanner.Bytes()
+
+      if(len(bytes.TrimSpace(line)) == 0){
+        continue
+      }
+
+      var v T
+
+      if derr := r.decodeJSON(line, &v, "ndjson"); derr != nil {
+        yield(zero, derr)
+        return
+      }
+
+      if(!yield(v, nil)){
+        return
+      }
+    }
+
+    err = scanner.Err()
+
+    if(errors.Is(err, bufio.ErrTooLong)){
+      err = fmt.Errorf("%w: ndjson line over %d bytes", ErrBodyTooLarge, max)
+    }
+
+    if(err != nil){
+      yield(zero, r.transportError(err))
+    }
+  }
+}
+
+//
+// func EachJSONLine[T any](ctx context.Context, r *Restapi, fn func(T) error) error
+//
+// JSONLines() with a callback.  Stops at the first error, including
+// one returned by fn
+//
+
+func EachJSONLine[T any](ctx context.Context, r *Restapi, fn func(T) error) error {
+
+  for v, err := range JSONLines[T](ctx, r) {
+
+    if(err != nil){
+      return err
+    }
+
+    if err := fn(v); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
@@ -0,0 +1,148 @@
+package restapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ndjsonLine struct {
+	N    int    `json:"n"`
+	Name string `json:"name"`
+}
+
+func ndjsonServer(body string) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(body))
+	}))
+}
+
+func TestJSONLines(t *testing.T) {
+
+	// blank lines, CRLF and no newline on the last line
+
+	srv := ndjsonServer("{\"n\":1,\"name\":\"a\"}\n\n{\"n\":2,\"name\":\"b\"}\r\n  \n{\"n\":3,\"name\":\"c\"}")
+	defer srv.Close()
+
+	var got []ndjsonLine
+
+	for v, err := range JSONLines[ndjsonLine](context.Background(), NewGet("lines", srv.URL)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+
+	if len(got) != 3 || got[0].Name != "a" || got[1].N != 2 || got[2].Name != "c" {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestJSONLinesBadLine(t *testing.T) {
+
+	srv := ndjsonServer("{\"n\":1}\nnot json\n{\"n\":3}\n")
+	defer srv.Close()
+
+	var n int
+
+	err := EachJSONLine(context.Background(), NewGet("lines", srv.URL), func(v ndjsonLine) error {
+		n++
+		return nil
+	})
+
+	var decodeErr *DecodeError
+
+	if !errors.As(err, &decodeErr) || n != 1 {
+		t.Fatalf("err = %v after %d lines, want a DecodeError after 1", err, n)
+	}
+}
+
+func TestJSONLinesStrict(t *testing.T) {
+
+	srv := ndjsonServer("{\"n\":1,\"extra\":true}\n")
+	defer srv.Close()
+
+	r := NewGet("lines", srv.URL)
+	r.SetStrictDecode(true)
+
+	err := EachJSONLine(context.Background(), r, func(v ndjsonLine) error { return nil })
+
+	var decodeErr *DecodeError
+
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("err = %v, want a DecodeError for the unknown field", err)
+	}
+}
+
+func TestEachJSONLineStopsOnCallbackError(t *testing.T) {
+
+	srv := ndjsonServer("{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n")
+	defer srv.Close()
+
+	stop := errors.New("stop")
+
+	var n int
+
+	err := EachJSONLine(context.Background(), NewGet("lines", srv.URL), func(v ndjsonLine) error {
+		n++
+		if v.N == 2 {
+			return stop
+		}
+		return nil
+	})
+
+	if err != stop || n != 2 {
+		t.Fatalf("err = %v after %d lines", err, n)
+	}
+}
+
+func TestJSONLinesLineLimit(t *testing.T) {
+
+	// a line that never ends is refused, not buffered without limit
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"n\":1}\n{\"name\":\"" + strings.Repeat("x", 4096) + "\"}"))
+	}))
+	defer srv.Close()
+
+	r := NewGet("lines", srv.URL)
+	r.SetMaxBodySize(1024)
+
+	var got []ndjsonLine
+	var err error
+
+	for v, e := range JSONLines[ndjsonLine](context.Background(), r) {
+		if e != nil {
+			err = e
+			break
+		}
+		got = append(got, v)
+	}
+
+	if len(got) != 1 || !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("got %+v, err = %v - want one line then ErrBodyTooLarge", got, err)
+	}
+
+	// under the limit it is fine
+
+	r = NewGet("lines", srv.URL)
+	r.SetMaxBodySize(8192)
+
+	got = nil
+
+	for v, e := range JSONLines[ndjsonLine](context.Background(), r) {
+		if e != nil {
+			t.Fatalf("within the limit: %v", e)
+		}
+		got = append(got, v)
+	}
+
+	if len(got) != 2 || len(got[1].Name) != 4096 {
+		t.Errorf("got %d lines", len(got))
+	}
+}
@@ -0,0 +1,565 @@
+//
+//
+// restapi - oauth2
+//
+// Token handling so callers don't have to fetch an access token with
+// one Restapi and then SetBearerAccessToken() on every other one.  A
+// TokenSource set on a Client (or a single Restapi) supplies the
+// Authorization header, caches the token for its expires_in, refreshes
+// it a little before it runs out and gets a new one if the server
+// answers 401
+//
+// Supported grants - password, client credentials, refresh token and
+// authorization code with PKCE
+//
+//   cfg := &restapi.OAuth2Config{ TokenUrl: "https://auth.example.com/oauth2/token",
+//                                 ClientID: "ownerapi" }
+//
+//   client := restapi.NewClient()
+//   client.SetTokenSource(cfg.PasswordTokenSource("me@example.com", "secret"))
+//
+//   vehicles := client.NewGet("vehicles", "https://owner-api.example.com/api/1/vehicles")
+//
+//
+
+package restapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Token as returned by a token endpoint
+//
+
+type Token struct {
+  AccessToken  string `json:"access_token"`
+  TokenType    string `json:"token_type,omitempty"`
+  RefreshToken string `json:"refresh_token,omitempty"`
+  ExpiresIn    int64  `json:"expires_in,omitempty"` // seconds
+  CreatedAt    int64  `json:"created_at,omitempty"` // unix time, some servers (Tesla) send it
+  Scope        string `json:"scope,omitempty"`
+
+  Expiry time.Time `json:"expiry"` // zero means it does not expire
+}
+
+//
+// func (t *Token) Valid() bool
+//
+// Has an access token that has not expired
+//
+
+func (t *Token) Valid() bool {
+  return t != nil && t.AccessToken != "" && !t.expiresWithin(0)
+}
+
+//
+// func (t *Token) expiresWithin(d time.Duration) bool
+//
+// Will the token have expired d from now
+//
+
+func (t *Token) expiresWithin(d time.Duration) bool {
+
+  if(t.Expiry.IsZero()){
+    return false
+  }
+
+  return time.Now().Add(d).After(t.Expiry)
+}
+
+//
+// func (t *Token) authorization() string
+//
+// Authorization header value - "Bearer <token>" unless the server said
+// some other type
+//
+
+func (t *Token) authorization() string {
+
+  tokenType := t.TokenType
+
+  if(tokenType == "" || strings.EqualFold(tokenType, "bearer")){
+    tokenType = "Bearer"
+  }
+
+  return tokenType + " " + t.AccessToken
+}
+
+//
+// func (t *Token) setExpiry()
+//
+// Works out Expiry from expires_in (and created_at when sent)
+//
+
+func (t *Token) setExpiry() {
+
+  if(t.ExpiresIn <= 0){
+    return
+  }
+
+  start := time.Now()
+
+  if(t.CreatedAt > 0){
+    start = time.Unix(t.CreatedAt, 0)
+  }
+
+  t.Expiry = start.Add(time.Duration(t.ExpiresIn) * time.Second)
+}
+
+//
+// TokenSource hands out tokens, fetching or refreshing as needed
+//
+
+type TokenSource interface {
+  Token(ctx context.Context) (*Token, error)
+}
+
+//
+// A TokenSource that can be told its token was rejected
+//
+
+type TokenInvalidator interface {
+  Invalidate()
+}
+
+//
+// OAuth2Error
+//
+// The token endpoint said no - Code is the oauth2 "error" field
+// (invalid_grant, invalid_client, ...).  The *StatusError is underneath
+//
+
+type OAuth2Error struct {
+  Code        string `json:"error"`
+  Description string `json:"error_description"`
+  Err         error  `json:"-"`
+}
+
+func (e *OAuth2Error) Error() string {
+
+  if(e.Description != ""){
+    return fmt.Sprintf("oauth2: %s - %s", e.Code, e.Description)
+  }
+
+  return fmt.Sprintf("oauth2: %s", e.Code)
+}
+
+func (e *OAuth2Error) Unwrap() error {
+  return e.Err
+}
+
+type OAuth2Config struct {
+
+  TokenUrl    string
+  AuthUrl     string   // authorization code grant only
+  RedirectUrl string   // authorization code grant only
+
+  ClientID     string
+  ClientSecret string
+  Scopes       []string
+
+  ClientAuthBasic bool // send client id/secret as Basic auth instead of form fields
+
+  RefreshBefore time.Duration // refresh this long before expiry (default 1 minute)
+
+  Client *Client // used to call the token endpoint, nil = DefaultClient
+
+}
+
+//
+// func (cfg *OAuth2Config) PasswordToken(ctx context.Context, username string, password string) (*Token, error)
+//
+// Resource owner password grant
+//
+
+func (cfg *OAuth2Config) PasswordToken(ctx context.Context, username string, password string) (*Token, error) {
+
+  form := url.Values{ "grant_type": { "password" },
+                      "username": { username },
+                      "password": { password } }
+
+  cfg.addScopes(form)
+
+  return cfg.fetchToken(ctx, form)
+}
+
+//
+// func (cfg *OAuth2Config) ClientCredentialsToken(ctx context.Context) (*Token, error)
+//
+// Client credentials grant - machine to machine, no user
+//
+
+func (cfg *OAuth2Config) ClientCredentialsToken(ctx context.Context) (*Token, error) {
+
+  form := url.Values{ "grant_type": { "client_credentials" } }
+
+  cfg.addScopes(form)
+
+  return cfg.fetchToken(ctx, form)
+}
+
+//
+// func (cfg *OAuth2Config) RefreshToken(ctx context.Context, refreshToken string) (*Token, error)
+//
+// Trades a refresh token for a new access token.  If the server does
+// not send a new refresh token the old one is kept
+//
+
+func (cfg *OAuth2Config) RefreshToken(ctx context.Context, refreshToken string) (*Token, error) {
+
+  form := url.Values{ "grant_type": { "refresh_token" },
+                      "refresh_token": { refreshToken } }
+
+  tok, err := cfg.fetchToken(ctx, form)
+
+  if(err != nil){
+    return nil, err
+  }
+
+  if(tok.RefreshToken == ""){
+    tok.RefreshToken = refreshToken
+  }
+
+  return tok, nil
+}
+
+//
+// func NewPKCEVerifier() string
+//
+// Random code verifier for the authorization code + PKCE flow.  Keep
+// it until Exchange()
+//
+
+func NewPKCEVerifier() string {
+
+  b := make([]byte, 32)
+
+  rand.Read(b)
+
+  return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//
+// func (cfg *OAuth2Config) AuthCodeURL(state string, verifier string) string
+//
+// Url to send the user to for the authorization code grant, using the
+// S256 PKCE challenge for verifier
+//
+// state - opaque value checked when the redirect comes back
+// verifier - from NewPKCEVerifier(), "" to skip PKCE
+//
+
+func (cfg *OAuth2Config) AuthCodeURL(state string, verifier string) string {
+
+  v := url.Values{ "response_type": { "code" },
+                   "client_id": { cfg.ClientID } }
+
+  if(cfg.RedirectUrl != ""){
+    v.Set("redirect_uri", cfg.RedirectUrl)
+  }
+
+  if(state != ""){
+    v.Set("state", state)
+  }
+
+  cfg.addScopes(v)
+
+  if(verifier != ""){
+    sum := sha256.Sum256([]byte(verifier))
+    v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+    v.Set("code_challenge_method", "S256")
+  }
+
+  if(strings.Contains(cfg.AuthUrl, "?")){
+    return cfg.AuthUrl + "&" + v.Encode()
+  }
+
+  return cfg.AuthUrl + "?" + v.Encode()
+}
+
+//
+// func (cfg *OAuth2Config) Exchange(ctx context.Context, code string, verifier string) (*Token, error)
+//
+// Trades the code from the authorization redirect for a token
+//
+// code - code query parameter from the redirect
+// verifier - the same verifier given to AuthCodeURL()
+//
+
+func (cfg *OAuth2Config) Exchange(ctx context.Context, code string, verifier string) (*Token, error) {
+
+  form := url.Values{ "grant_type": { "authorization_code" },
+                      "code": { code } }
+
+  if(cfg.RedirectUrl != ""){
+    form.Set("redirect_uri", cfg.RedirectUrl)
+  }
+
+  if(verifier != ""){
+    form.Set("code_verifier", verifier)
+  }
+
+  return cfg.fetchToken(ctx, form)
+}
+
+//
+// func (cfg *OAuth2Config) PasswordTokenSource(username string, password string) TokenSource
+//
+// Logs in with the password grant when first needed, then refreshes
+// (falling back to logging in again if the refresh is refused)
+//
+
+func (cfg *OAuth2Config) PasswordTokenSource(username string, password string) TokenSource {
+
+  return &cachingTokenSource{ cfg: cfg,
+                              fetch: func(ctx context.Context) (*Token, error) {
+                                return cfg.PasswordToken(ctx, username, password)
+                              } }
+}
+
+//
+// func (cfg *OAuth2Config) ClientCredentialsTokenSource() TokenSource
+//
+// Fetches client credentials tokens as needed
+//
+
+func (cfg *OAuth2Config) ClientCredentialsTokenSource() TokenSource {
+  return &cachingTokenSource{ cfg: cfg, fetch: cfg.ClientCredentialsToken }
+}
+
+//
+// func (cfg *OAuth2Config) TokenSource(tok *Token) TokenSource
+//
+// Starts from a token you already have (from Exchange(), or saved from
+// a previous run) and keeps it fresh with its refresh token
+//
+
+func (cfg *OAuth2Config) TokenSource(tok *Token) TokenSource {
+  return &cachingTokenSource{ cfg: cfg, pToken: tok }
+}
+
+//
+// func StaticTokenSource(tok *Token) TokenSource
+//
+// Always hands back tok - for tokens managed elsewhere
+//
+
+func StaticTokenSource(tok *Token) TokenSource {
+  return staticTokenSource{ pToken: tok }
+}
+
+type staticTokenSource struct {
+  pToken *Token
+}
+
+func (s staticTokenSource) Token(_ context.Context) (*Token, error) {
+  return s.pToken, nil
+}
+
+//
+// func (c *Client) SetTokenSource(ts TokenSource)
+//
+// Every request from this client gets its Authorization header from
+// ts - same as AddAuthenticator(TokenAuth(ts)).  nil to stop
+//
+
+func (c *Client) SetTokenSource(ts TokenSource){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.pTokenSource = ts
+}
+
+//
+// func (c *Client) GetTokenSource() TokenSource
+//
+// Returns the client's token source, nil if none
+//
+
+func (c *Client) GetTokenSource() TokenSource{
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  return c.pTokenSource
+}
+
+//
+// func (pRA *Restapi) SetTokenSource(ts TokenSource)
+//
+// This request gets its Authorization header from ts (overriding the
+// client's token source).  nil to remove
+//
+
+func (pRA *Restapi) SetTokenSource(ts TokenSource){
+  pRA.pTokenSource = ts
+}
+
+//
+// cachingTokenSource
+//
+// Keeps the current token, refreshing it RefreshBefore its expiry
+//
+
+type cachingTokenSource struct {
+
+  mu sync.Mutex
+
+  cfg    *OAuth2Config
+  fetch  func(ctx context.Context) (*Token, error) // new token from scratch, may be nil
+  pToken *Token
+
+}
+
+func (s *cachingTokenSource) Token(ctx context.Context) (*Token, error) {
+
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  before := s.cfg.RefreshBefore
+
+  if(before == 0){
+    before = time.Minute
+  }
+
+  if(s.pToken != nil && s.pToken.AccessToken != "" && !s.pToken.expiresWithin(before)){
+    return s.pToken, nil
+  }
+
+  if(s.pToken != nil && s.pToken.RefreshToken != ""){
+
+    tok, err := s.cfg.RefreshToken(ctx, s.pToken.RefreshToken)
+
+    if(err == nil){
+      s.pToken = tok
+      return tok, nil
+    }
+
+    if(s.fetch == nil){
+      return nil, err
+    }
+  }
+
+  if(s.fetch == nil){
+    return nil, fmt.Errorf("oauth2: token expired and no refresh token")
+  }
+
+  tok, err := s.fetch(ctx)
+
+  if(err != nil){
+    return nil, err
+  }
+
+  s.pToken = tok
+
+  return tok, nil
+}
+
+//
+// func (s *cachingTokenSource) Invalidate()
+//
+// Forces a refresh on the next Token() call
+//
+
+func (s *cachingTokenSource) Invalidate() {
+
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if(s.pToken != nil){
+    tok := *s.pToken
+    tok.AccessToken = ""
+    s.pToken = &tok
+  }
+}
+
+//
+// func (cfg *OAuth2Config) addScopes(v url.Values)
+//
+// Space separated scope parameter, if we have any
+//
+
+func (cfg *OAuth2Config) addScopes(v url.Values) {
+
+  if(len(cfg.Scopes) > 0){
+    v.Set("scope", strings.Join(cfg.Scopes, " "))
+  }
+}
+
+//
+// func (cfg *OAuth2Config) fetchToken(ctx context.Context, form url.Values) (*Token, error)
+//
+// Posts form to the token endpoint
+//
+
+func (cfg *OAuth2Config) fetchToken(ctx context.Context, form url.Values) (*Token, error) {
+
+  client := cfg.Client
+
+  if(client == nil){
+    client = DefaultClient
+  }
+
+  r := client.NewPost("oauth2 token", cfg.TokenUrl)
+
+  r.bNoClientAuth = true
+
+  if(cfg.ClientAuthBasic){
+    creds := url.QueryEscape(cfg.ClientID) + ":" + url.QueryEscape(cfg.ClientSecret)
+    r.SetBasicAccessToken(base64.StdEncoding.EncodeToString([]byte(creds)))
+  }else{
+    form.Set("client_id", cfg.ClientID)
+
+    if(cfg.ClientSecret != ""){
+      form.Set("client_secret", cfg.ClientSecret)
+    }
+  }
+
+  r.SetHeader("Accept", "application/json")
+  r.SetBodyForm(form)
+  r.JsonOnly()
+
+  resp, err := r.DoContext(ctx)
+
+  if(err != nil){
+
+    var statusErr *StatusError
+
+    if(errors.As(err, &statusErr)){
+      oerr := &OAuth2Error{ Err: err }
+
+      if(json.Unmarshal(statusErr.Body, oerr) == nil && oerr.Code != ""){
+        return nil, oerr
+      }
+    }
+
+    return nil, err
+  }
+
+  tok := new(Token)
+
+  if err := json.Unmarshal(resp.Body, tok); err != nil {
+    return nil, &DecodeError{ Name: r.sName, Format: "json", Err: err, Body: resp.Body }
+  }
+
+  if(tok.AccessToken == ""){
+    return nil, &DecodeError{ Name: r.sName, Format: "json",
+                              Err: fmt.Errorf("no access_token in response"), Body: resp.Body }
+  }
+
+  tok.setExpiry()
+
+  return tok, nil
+}
@@ -0,0 +1,145 @@
+package restapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//
+// tokenServer hands out "tok1", "tok2"... for refresh_token grants and
+// counts them
+//
+
+func tokenServer(refreshes *atomic.Int32) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		r.ParseForm()
+
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		n := refreshes.Add(1)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"tok%d","token_type":"Bearer","expires_in":3600,"refresh_token":"r%d"}`, n, n)
+	}))
+}
+
+func TestOAuth2RefreshBeforeExpiry(t *testing.T) {
+
+	var refreshes atomic.Int32
+
+	srv := tokenServer(&refreshes)
+	defer srv.Close()
+
+	cfg := &OAuth2Config{TokenUrl: srv.URL, ClientID: "id", RefreshBefore: time.Minute}
+
+	// fresh for another hour - no refresh
+
+	ts := cfg.TokenSource(&Token{AccessToken: "old", RefreshToken: "r0", Expiry: time.Now().Add(time.Hour)})
+
+	tok, err := ts.Token(context.Background())
+
+	if err != nil || tok.AccessToken != "old" || refreshes.Load() != 0 {
+		t.Fatalf("tok = %v, err = %v, refreshes = %d", tok, err, refreshes.Load())
+	}
+
+	// inside the RefreshBefore window - refreshed before it expires
+
+	ts = cfg.TokenSource(&Token{AccessToken: "old", RefreshToken: "r0", Expiry: time.Now().Add(30 * time.Second)})
+
+	tok, err = ts.Token(context.Background())
+
+	if err != nil || tok.AccessToken != "tok1" || tok.RefreshToken != "r1" {
+		t.Fatalf("tok = %v, err = %v", tok, err)
+	}
+
+	// and cached after that
+
+	tok, _ = ts.Token(context.Background())
+
+	if tok.AccessToken != "tok1" || refreshes.Load() != 1 {
+		t.Fatalf("tok = %s, refreshes = %d", tok.AccessToken, refreshes.Load())
+	}
+}
+
+func TestOAuth2RetryOnceOn401(t *testing.T) {
+
+	var refreshes atomic.Int32
+
+	tokens := tokenServer(&refreshes)
+	defer tokens.Close()
+
+	var hits atomic.Int32
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		hits.Add(1)
+
+		if r.Header.Get("Authorization") != "Bearer tok1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer api.Close()
+
+	cfg := &OAuth2Config{TokenUrl: tokens.URL, ClientID: "id"}
+
+	// the server has revoked "old" even though it hasn't expired
+
+	r := NewGet("api", api.URL)
+	r.SetTokenSource(cfg.TokenSource(&Token{AccessToken: "old", RefreshToken: "r0"}))
+
+	if _, err := r.Do(); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	if hits.Load() != 2 || refreshes.Load() != 1 {
+		t.Fatalf("hits = %d, refreshes = %d, want 2 and 1", hits.Load(), refreshes.Load())
+	}
+}
+
+func TestOAuth2No401Loop(t *testing.T) {
+
+	var refreshes atomic.Int32
+
+	tokens := tokenServer(&refreshes)
+	defer tokens.Close()
+
+	var hits atomic.Int32
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer api.Close()
+
+	cfg := &OAuth2Config{TokenUrl: tokens.URL, ClientID: "id"}
+
+	r := NewGet("api", api.URL)
+	r.SetTokenSource(cfg.TokenSource(&Token{AccessToken: "old", RefreshToken: "r0"}))
+
+	_, err := r.Do()
+
+	var statusErr *StatusError
+
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("err = %v, want a 401 StatusError", err)
+	}
+
+	if hits.Load() != 2 {
+		t.Fatalf("hits = %d, want 2 (one retry)", hits.Load())
+	}
+}
@@ -0,0 +1,285 @@
+//
+//
+// restapi - certificate pinning
+//
+// Self signed devices (Powerwall gateway and friends) can't be verified
+// the normal way.  Rather than turning verification off, pin the
+// certificate (or just its public key) the device presented and only
+// talk to a server presenting that exact one.  Hostname and chain are
+// not checked when pins are set - the pin is the trust
+//
+// Trust on first use:
+//
+//   r := restapi.NewGet("status", "https://192.168.1.50/api/status")
+//   err := r.PinCertificateTOFU("powerwall.pem")
+//
+// fetches and saves the certificate the first time, then pins the
+// saved one from then on
+//
+//
+
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrPinMismatch = errors.New("restapi: server certificate does not match pin")
+
+//
+// func SPKIHash(cert *x509.Certificate) []byte
+//
+// sha256 of the certificate's public key (SubjectPublicKeyInfo).  Stays
+// the same when a device re-issues its certificate with the same key
+//
+
+func SPKIHash(cert *x509.Certificate) []byte {
+
+  sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+  return sum[:]
+}
+
+//
+// func SaveCertPEM(cert *x509.Certificate, filename string) error
+//
+// Writes the certificate out as PEM, readable by LoadCertPEM() and
+// UseCert()
+//
+
+func SaveCertPEM(cert *x509.Certificate, filename string) error {
+
+  data := pem.EncodeToMemory(&pem.Block{ Type: "CERTIFICATE", Bytes: cert.Raw })
+
+  err := os.WriteFile(filename, data, 0644)
+
+  if(err != nil){
+    return &CertError{ File: filename, Err: err }
+  }
+
+  return nil
+}
+
+//
+// func LoadCertPEM(filename string) (*x509.Certificate, error)
+//
+// Reads the first certificate from a PEM file
+//
+
+func LoadCertPEM(filename string) (*x509.Certificate, error) {
+
+  data, err := os.ReadFile(filename)
+
+  if(err != nil){
+    return nil, &CertError{ File: filename, Err: err }
+  }
+
+  for {
+    var block *pem.Block
+
+    block, data = pem.Decode(data)
+
+    if(block == nil){
+      return nil, &CertError{ File: filename, Err: fmt.Errorf("no PEM certificates found") }
+    }
+
+    if(block.Type != "CERTIFICATE"){
+      continue
+    }
+
+    cert, err := x509.ParseCertificate(block.Bytes)
+
+    if(err != nil){
+      return nil, &CertError{ File: filename, Err: err }
+    }
+
+    return cert, nil
+  }
+}
+
+//
+// func (c *Client) PinCertificate(cert *x509.Certificate)
+//
+// Only trust servers presenting exactly this certificate.  May be
+// called more than once to allow several
+//
+
+func (c *Client) PinCertificate(cert *x509.Certificate){
+
+  opts := c.GetTLSOptions()
+  opts.PinnedCerts = append(opts.PinnedCerts, cert)
+
+  c.SetTLSOptions(opts)
+}
+
+//
+// func (c *Client) PinSPKIHash(hash []byte)
+//
+// Only trust servers whose public key hashes to this (see SPKIHash())
+//
+
+func (c *Client) PinSPKIHash(hash []byte){
+
+  opts := c.GetTLSOptions()
+  opts.PinnedSPKIHashes = append(opts.PinnedSPKIHashes, hash)
+
+  c.SetTLSOptions(opts)
+}
+
+//
+// func (c *Client) ClearPins()
+//
+// Back to normal certificate verification
+//
+
+func (c *Client) ClearPins(){
+
+  opts := c.GetTLSOptions()
+  opts.PinnedCerts = nil
+  opts.PinnedSPKIHashes = nil
+
+  c.SetTLSOptions(opts)
+}
+
+//
+// func (pRA *Restapi) PinCertificate(cert *x509.Certificate)
+//
+// Only trust a server presenting exactly this certificate for this
+// request - e.g. one returned by FetchTLSCert()
+//
+
+func (pRA *Restapi) PinCertificate(cert *x509.Certificate){
+
+  opts := pRA.GetTLSOptions()
+  opts.PinnedCerts = append(opts.PinnedCerts, cert)
+
+  pRA.SetTLSOptions(opts)
+}
+
+//
+// func (pRA *Restapi) PinSPKIHash(hash []byte)
+//
+// Only trust a server whose public key hashes to this for this request
+//
+
+func (pRA *Restapi) PinSPKIHash(hash []byte){
+
+  opts := pRA.GetTLSOptions()
+  opts.PinnedSPKIHashes = append(opts.PinnedSPKIHashes, hash)
+
+  pRA.SetTLSOptions(opts)
+}
+
+//
+// func (pRA *Restapi) ClearPins()
+//
+// Remove any pins set on this request
+//
+
+func (pRA *Restapi) ClearPins(){
+
+  opts := pRA.GetTLSOptions()
+  opts.PinnedCerts = nil
+  opts.PinnedSPKIHashes = nil
+
+  pRA.SetTLSOptions(opts)
+}
+
+//
+// func (pRA *Restapi) PinCertificateTOFU(pemfile string) error
+//
+// Trust on first use.  If pemfile exists its certificate is pinned,
+// otherwise the certificate the server presents now is fetched, saved
+// to pemfile and pinned
+//
+
+func (pRA *Restapi) PinCertificateTOFU(pemfile string) error {
+
+  if _, err := os.Stat(pemfile); err == nil {
+
+    cert, err := LoadCertPEM(pemfile)
+
+    if(err != nil){
+      return err
+    }
+
+    pRA.PinCertificate(cert)
+    return nil
+  }
+
+  opts := &FetchOptions{ ServerName: pRA.GetTLSOptions().ServerName }
+
+  chain, err := FetchTLSChain(context.Background(), pRA.sUrl, opts)
+
+  if(err != nil){
+    return err
+  }
+
+  cert := chain.Certificates[0]
+
+  err = SaveCertPEM(cert, pemfile)
+
+  if(err != nil){
+    return err
+  }
+
+  pRA.PinCertificate(cert)
+
+  return nil
+}
+
+//
+// func (o *TLSOptions) verifyPins(rawCerts [][]byte, _ [][]*x509.Certificate) error
+//
+// tls VerifyPeerCertificate callback - the leaf must match a pinned
+// certificate or public key
+//
+
+func (o *TLSOptions) verifyPins(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+
+  if(len(rawCerts) == 0){
+    return ErrPinMismatch
+  }
+
+  for _, pinned := range o.PinnedCerts {
+    if(bytes.Equal(pinned.Raw, rawCerts[0])){
+      return nil
+    }
+  }
+
+  if(len(o.PinnedSPKIHashes) > 0){
+
+    leaf, err := x509.ParseCertificate(rawCerts[0])
+
+    if(err != nil){
+      return err
+    }
+
+    hash := SPKIHash(leaf)
+
+    for _, pinned := range o.PinnedSPKIHashes {
+      if(bytes.Equal(pinned, hash)){
+        return nil
+      }
+    }
+  }
+
+  return ErrPinMismatch
+}
+
+//
+// func (o *TLSOptions) hasPins() bool
+//
+// Any certificate or key pins set
+//
+
+func (o *TLSOptions) hasPins() bool {
+  return len(o.PinnedCerts) > 0 || len(o.PinnedSPKIHashes) > 0
+}
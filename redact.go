@@ -0,0 +1,517 @@
+//
+//
+// restapi - redaction of secrets
+//
+// Dump(), debug output and the urls in logged errors have credentials
+// masked so they don't end up in log files - Authorization and api key
+// headers, cookies, and query parameters, form fields and json keys
+// like app_code, password or access_token.
+// The scheme of an Authorization header is left visible ("Bearer
+// [REDACTED]") as it helps when debugging
+//
+//   restapi.DefaultClient.AddSensitiveParam("sig")
+//   r.SetShowSecrets(true) // only while debugging by hand
+//
+//
+
+package restapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var defaultSensitiveHeaders = []string{ "Authorization",
+                                        "Proxy-Authorization",
+                                        "Cookie",
+                                        "Set-Cookie",
+                                        "X-Api-Key",
+                                        "X-Amz-Security-Token",
+                                        "X-Signature" }
+
+var defaultSensitiveParams = []string{ "access_token",
+                                       "api_key",
+                                       "apikey",
+                                       "app_code",
+                                       "client_secret",
+                                       "code_verifier",
+                                       "key",
+                                       "password",
+                                       "refresh_token",
+                                       "secret",
+                                       "signature",
+                                       "token",
+                                       "x-amz-credential",
+                                       "x-amz-security-token",
+                                       "x-amz-signature" }
+
+//
+// Which names count as secret.  Matching is case insensitive
+//
+
+type redactor struct {
+  mHeaders map[string]bool // canonical header names
+  mParams  map[string]bool // lower cased query/form names
+  bShow    bool            // show everything
+}
+
+func newRedactor() *redactor {
+
+  r := &redactor{ mHeaders: make(map[string]bool), mParams: make(map[string]bool) }
+
+  for _, h := range defaultSensitiveHeaders {
+    r.mHeaders[http.CanonicalHeaderKey(h)] = true
+  }
+
+  for _, p := range defaultSensitiveParams {
+    r.mParams[p] = true
+  }
+
+  return r
+}
+
+func (r *redactor) clone() *redactor {
+
+  c := &redactor{ mHeaders: make(map[string]bool), mParams: make(map[string]bool), bShow: r.bShow }
+
+  for k := range r.mHeaders {
+    c.mHeaders[k] = true
+  }
+
+  for k := range r.mParams {
+    c.mParams[k] = true
+  }
+
+  return c
+}
+
+//
+// func (r *redactor) url(raw string) string
+//
+// raw with the user info password and sensitive query values masked.
+// Parameter order is kept
+//
+
+func (r *redactor) url(raw string) string {
+
+  if(r.bShow || raw == ""){
+    return raw
+  }
+
+  u, err := url.Parse(raw)
+
+  if(err != nil){
+    // can't tell what's in it - hide the query to be safe
+    if i := strings.IndexByte(raw, '?'); i >= 0 {
+      return raw[:i] + "?" + redacted
+    }
+    return raw
+  }
+
+  if _, has := u.User.Password(); has {
+    u.User = url.UserPassword(u.User.Username(), redacted)
+  }
+
+  u.RawQuery = r.query(u.RawQuery)
+
+  // the user info gets escaped - keep [REDACTED] readable
+  return strings.Replace(u.String(), "%5BREDACTED%5D", redacted, 1)
+}
+
+//
+// func (r *redactor) query(raw string) string
+//
+// A raw (encoded) query or form body with sensitive values masked
+//
+
+func (r *redactor) query(raw string) string {
+
+  if(r.bShow || raw == ""){
+    return raw
+  }
+
+  pairs := strings.Split(raw, "&")
+
+  for i, pair := range pairs {
+    key, _, found := strings.Cut(pair, "=")
+
+    if(!found){
+      continue
+    }
+
+    name, err := url.QueryUnescape(key)
+
+    if(err != nil){
+      name = key
+    }
+
+    if(r.mParams[strings.ToLower(name)]){
+      pairs[i] = key + "=" + redacted
+    }
+  }
+
+  return strings.Join(pairs, "&")
+}
+
+//
+// func (r *redactor) header(h http.Header) http.Header
+//
+// A copy of h with sensitive values masked
+//
+
+func (r *redactor) header(h http.Header) http.Header {
+
+  c := h.Clone()
+
+  if(r.bShow){
+    return c
+  }
+
+  for name, values := range c {
+    if(!r.mHeaders[http.CanonicalHeaderKey(name)]){
+      continue
+    }
+
+    for i, v := range values {
+      values[i] = r.credential(v)
+    }
+  }
+
+  return c
+}
+
+//
+// func (r *redactor) credential(value string) string
+//
+// "Bearer abc" -> "Bearer [REDACTED]", anything else -> "[REDACTED]"
+//
+
+func (r *redactor) credential(value string) string {
+
+  if(r.bShow || value == ""){
+    return value
+  }
+
+  scheme, _, found := strings.Cut(value, " ")
+
+  switch strings.ToLower(scheme) {
+    case "basic", "bearer", "digest", "token", "aws4-hmac-sha256":
+      if(found){
+        return scheme + " " + redacted
+      }
+  }
+
+  return redacted
+}
+
+//
+// func (r *redactor) error(err error) error
+//
+// Masks the url net/http puts in its *url.Error - it has the query
+// parameters authenticators added
+//
+
+func (r *redactor) error(err error) error {
+
+  uerr, ok := err.(*url.Error)
+
+  if(r.bShow || !ok){
+    return err
+  }
+
+  return &url.Error{ Op: uerr.Op, URL: r.url(uerr.URL), Err: uerr.Err }
+}
+
+//
+// func (r *redactor) body(contentType string, body string) string
+//
+// Form and json bodies get their sensitive fields masked - password
+// grants going out, access/refresh tokens coming back
+//
+
+func (r *redactor) body(contentType string, body string) string {
+
+  if(r.bShow || body == ""){
+    return body
+  }
+
+  mediaType, _, _ := mime.ParseMediaType(contentType)
+
+  if(mediaType == "application/x-www-form-urlencoded"){
+    return r.query(body)
+  }
+
+  trimmed := strings.TrimSpace(body)
+
+  if(mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") ||
+     strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")){
+    return r.json(body)
+  }
+
+  return body
+}
+
+//
+// func (r *redactor) json(body string) string
+//
+// body with the values of sensitive keys masked, at any depth.  Keys
+// come out sorted.  Left alone if it isn't json
+//
+
+func (r *redactor) json(body string) string {
+
+  dec := json.NewDecoder(strings.NewReader(body))
+  dec.UseNumber() // print numbers as they came
+
+  var v any
+
+  if err := dec.Decode(&v); err != nil {
+    return body
+  }
+
+  var out bytes.Buffer
+
+  enc := json.NewEncoder(&out)
+  enc.SetEscapeHTML(false)
+
+  if err := enc.Encode(r.value(v)); err != nil {
+    return body
+  }
+
+  return strings.TrimSuffix(out.String(), "\n")
+}
+
+//
+// func (r *redactor) value(v any) any
+//
+// Copy of decoded json (RawData) with sensitive keys masked
+//
+
+func (r *redactor) value(v any) any {
+
+  if(r.bShow){
+    return v
+  }
+
+  switch t := v.(type) {
+
+    case map[string]any:
+      c := make(map[string]any, len(t))
+
+      for k, x := range t {
+        if(r.mParams[strings.ToLower(k)]){
+          c[k] = redacted
+        }else{
+          c[k] = r.value(x)
+        }
+      }
+
+      return c
+
+    case []any:
+      c := make([]any, len(t))
+
+      for i, x := range t {
+        c[i] = r.value(x)
+      }
+
+      return c
+  }
+
+  return v
+}
+
+//
+// func (c *Client) AddSensitiveHeader(names ...string)
+//
+// Headers to mask on top of Authorization, Cookie, X-Api-Key etc
+//
+
+func (c *Client) AddSensitiveHeader(names ...string){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  for _, name := range names {
+    c.pRedactor.mHeaders[http.CanonicalHeaderKey(name)] = true
+  }
+}
+
+//
+// func (c *Client) AddSensitiveParam(names ...string)
+//
+// Query/form parameters to mask on top of app_code, access_token etc
+//
+
+func (c *Client) AddSensitiveParam(names ...string){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  for _, name := range names {
+    c.pRedactor.mParams[strings.ToLower(name)] = true
+  }
+}
+
+//
+// func (c *Client) SetSensitiveHeaders(names ...string)
+//
+// Replaces the list of masked headers - including the built in ones
+//
+
+func (c *Client) SetSensitiveHeaders(names ...string){
+
+  c.mu.Lock()
+  c.pRedactor.mHeaders = make(map[string]bool)
+  c.mu.Unlock()
+
+  c.AddSensitiveHeader(names...)
+}
+
+//
+// func (c *Client) SetSensitiveParams(names ...string)
+//
+// Replaces the list of masked parameters - including the built in ones
+//
+
+func (c *Client) SetSensitiveParams(names ...string){
+
+  c.mu.Lock()
+  c.pRedactor.mParams = make(map[string]bool)
+  c.mu.Unlock()
+
+  c.AddSensitiveParam(names...)
+}
+
+//
+// func (c *Client) GetSensitiveHeaders() []string
+//
+// The headers currently masked, sorted
+//
+
+func (c *Client) GetSensitiveHeaders() []string {
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  return sortedKeys(c.pRedactor.mHeaders)
+}
+
+//
+// func (c *Client) GetSensitiveParams() []string
+//
+// The parameters currently masked, sorted
+//
+
+func (c *Client) GetSensitiveParams() []string {
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  return sortedKeys(c.pRedactor.mParams)
+}
+
+//
+// func (c *Client) SetShowSecrets(show bool)
+//
+// true turns redaction off for every request on this client.  Don't
+// leave it on
+//
+
+func (c *Client) SetShowSecrets(show bool){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.pRedactor.bShow = show
+}
+
+//
+// func (pRA *Restapi) SetShowSecrets(show bool)
+//
+// true turns redaction off for this request's Dump(), debug output
+// and errors
+//
+
+func (pRA *Restapi) SetShowSecrets(show bool){
+  pRA.bShowSecrets = show
+}
+
+//
+// func (pRA *Restapi) redactor() *redactor
+//
+// Snapshot of the client's settings plus our own opt-in
+//
+
+func (pRA *Restapi) redactor() *redactor {
+
+  pRA.pClient.mu.Lock()
+  r := pRA.pClient.pRedactor.clone()
+  pRA.pClient.mu.Unlock()
+
+  r.bShow = r.bShow || pRA.bShowSecrets
+
+  return r
+}
+
+//
+// func (pRA *Restapi) debugRequest(req *http.Request)
+//
+// Prints the outgoing request for DebugOn() - secrets masked
+//
+
+func (pRA *Restapi) debugRequest(req *http.Request){
+
+  r := pRA.redactor()
+
+  fmt.Println("Request:", req.Method, r.url(req.URL.String()), req.Proto)
+
+  printHeader(r.header(req.Header))
+
+  if(pRA.sJsonStr != ""){
+    fmt.Println(r.body(req.Header.Get("Content-Type"), pRA.sJsonStr))
+  }
+}
+
+//
+// func (pRA *Restapi) debugResponse(res *http.Response)
+//
+// Prints the response status and headers for DebugOn() - secrets masked
+//
+
+func (pRA *Restapi) debugResponse(res *http.Response){
+
+  fmt.Println("Response:", res.Proto, res.Status)
+
+  printHeader(pRA.redactor().header(res.Header))
+}
+
+func printHeader(h http.Header){
+
+  for _, name := range sortedKeys(h) {
+    for _, v := range h[name] {
+      fmt.Printf("  %s: %s\n", name, v)
+    }
+  }
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+
+  keys := make([]string, 0, len(m))
+
+  for k := range m {
+    keys = append(keys, k)
+  }
+
+  sort.Strings(keys)
+
+  return keys
+}
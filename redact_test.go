@@ -0,0 +1,187 @@
+package restapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRedactorBody(t *testing.T) {
+
+	r := newRedactor()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"form", "application/x-www-form-urlencoded",
+			"grant_type=password&username=bob&password=hunter2",
+			"grant_type=password&username=bob&password=[REDACTED]"},
+		{"json", "application/json; charset=utf-8",
+			`{"access_token":"abc","expires_in":3600,"refresh_token":"def","token_type":"Bearer"}`,
+			`{"access_token":"[REDACTED]","expires_in":3600,"refresh_token":"[REDACTED]","token_type":"Bearer"}`},
+		{"nested json", "",
+			`{"data":[{"Password":"x","name":"<n>"}],"n":1.50}`,
+			`{"data":[{"Password":"[REDACTED]","name":"<n>"}],"n":1.50}`},
+		{"not json", "application/json", `{"token": broken`, `{"token": broken`},
+		{"text", "text/plain", "token=abc", "token=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.body(tt.contentType, tt.body); got != tt.want {
+				t.Errorf("body() =\n  %s\nwant\n  %s", got, tt.want)
+			}
+		})
+	}
+
+	r.bShow = true
+
+	if got := r.body("application/json", `{"token":"abc"}`); got != `{"token":"abc"}` {
+		t.Errorf("shown body = %s", got)
+	}
+}
+
+func TestRedactorValueCopies(t *testing.T) {
+
+	raw := map[string]any{"access_token": "abc", "inner": map[string]any{"secret": "s"}}
+
+	got := newRedactor().value(raw).(map[string]any)
+
+	if got["access_token"] != redacted || got["inner"].(map[string]any)["secret"] != redacted {
+		t.Fatalf("value() = %v", got)
+	}
+
+	if raw["access_token"] != "abc" || raw["inner"].(map[string]any)["secret"] != "s" {
+		t.Fatalf("original changed: %v", raw)
+	}
+}
+
+//
+// captureStdout returns what fn printed
+//
+
+func captureStdout(t *testing.T, fn func()) string {
+
+	t.Helper()
+
+	saved := os.Stdout
+
+	pr, pw, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Stdout = pw
+
+	done := make(chan string)
+
+	go func() {
+		out, _ := io.ReadAll(pr)
+		done <- string(out)
+	}()
+
+	fn()
+
+	pw.Close()
+	os.Stdout = saved
+
+	return <-done
+}
+
+func TestDebugOutputRedactsTokens(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"AT-sekrit","refresh_token":"RT-sekrit","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	send := func(show bool) string {
+
+		r := NewPost("token", srv.URL)
+		r.SetBodyForm(map[string][]string{"password": {"PW-sekrit"}})
+		r.JsonOnly()
+		r.SetShowSecrets(show)
+		r.DebugOn()
+
+		return captureStdout(t, func() {
+			if _, err := r.Do(); err != nil {
+				t.Errorf("Do: %v", err)
+			}
+		})
+	}
+
+	out := send(false)
+
+	for _, secret := range []string{"AT-sekrit", "RT-sekrit", "PW-sekrit"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("debug output has %s:\n%s", secret, out)
+		}
+	}
+
+	if !strings.Contains(out, "expires_in") {
+		t.Errorf("debug output lost the body:\n%s", out)
+	}
+
+	out = send(true)
+
+	if !strings.Contains(out, "AT-sekrit") || !strings.Contains(out, "PW-sekrit") {
+		t.Errorf("SetShowSecrets(true) output still masked:\n%s", out)
+	}
+}
+
+func TestDumpRedactsResponseAndForm(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"AT-sekrit","refresh_token":"RT-sekrit","expires_in":3600,` +
+			`"session":{"token":"ST-sekrit","user":"bob"},` +
+			`"items":[{"password":"PW2-sekrit","id":1}],"count":1}`))
+	}))
+	defer srv.Close()
+
+	dump := func(inner string, show bool) string {
+
+		r := NewPost("token", srv.URL)
+		r.SetBodyForm(map[string][]string{"password": {"PW-sekrit"}, "grant_type": {"password"}})
+		r.SetShowSecrets(show)
+
+		if inner == "map" {
+			r.HasInnerMap("session")
+		} else {
+			r.HasInnerMapArray("items", "count")
+		}
+
+		if !r.Send() {
+			t.Fatalf("Send failed, status %d", r.GetLastStatusCode())
+		}
+
+		return captureStdout(t, r.Dump)
+	}
+
+	for _, inner := range []string{"map", "array"} {
+
+		out := dump(inner, false)
+
+		for _, secret := range []string{"AT-sekrit", "RT-sekrit", "ST-sekrit", "PW-sekrit", "PW2-sekrit"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("Dump() has %s:\n%s", secret, out)
+			}
+		}
+
+		if !strings.Contains(out, "expires_in = 3600") || !strings.Contains(out, "grant_type=password") {
+			t.Errorf("Dump() lost the harmless values:\n%s", out)
+		}
+	}
+
+	if out := dump("map", true); !strings.Contains(out, "AT-sekrit") || !strings.Contains(out, "PW-sekrit") {
+		t.Errorf("SetShowSecrets(true) Dump() still masked:\n%s", out)
+	}
+}
@@ -0,0 +1,233 @@
+//
+//
+// restapi - response
+//
+// Response is what Do()/DoContext() hand back.  The same data is also
+// kept on the Restapi object so the older GetValue/GetResponseBody
+// style calls keep working
+//
+//
+
+package restapi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type Response struct {
+  StatusCode    int
+  Status        string
+  Header        http.Header
+  Body          []byte
+
+  ContentType   string
+  ContentLength int64  // -1 when the server did not say
+  Proto         string // HTTP/1.1, HTTP/2.0
+  FinalUrl      string // where we ended up after any redirects
+
+  RawData interface{} // decoded json (or xml converted to json), may be nil
+}
+
+//
+// func newResponse(res *http.Response) *Response
+//
+// Copies the metadata we care about out of the http response
+//
+
+func newResponse(res *http.Response) *Response {
+
+  resp := &Response{ StatusCode: res.StatusCode,
+                     Status: res.Status,
+                     Header: res.Header,
+                     ContentType: res.Header.Get("Content-Type"),
+                     ContentLength: res.ContentLength,
+                     Proto: res.Proto }
+
+  if(res.Request != nil && res.Request.URL != nil){
+    resp.FinalUrl = res.Request.URL.String()
+  }
+
+  return resp
+}
+
+//
+// func (r *Response) Location() string
+//
+// Location header (201 Created, 3xx) resolved against the final url
+//
+
+func (r *Response) Location() string {
+
+  loc := r.Header.Get("Location")
+
+  if(loc == "" || r.FinalUrl == ""){
+    return loc
+  }
+
+  base, err := url.Parse(r.FinalUrl)
+
+  if(err != nil){
+    return loc
+  }
+
+  ref, err := url.Parse(loc)
+
+  if(err != nil){
+    return loc
+  }
+
+  return base.ResolveReference(ref).String()
+}
+
+//
+// func (r *Response) ETag() string
+//
+// ETag header, pass it back with If-None-Match to avoid refetching
+//
+
+func (r *Response) ETag() string {
+  return r.Header.Get("ETag")
+}
+
+//
+// func (r *Response) Links() map[string]string
+//
+// Parses the Link header used for pagination into rel -> url, e.g.
+//
+//   <https://api/x?page=2>; rel="next", <https://api/x?page=9>; rel="last"
+//
+// gives {"next": "https://api/x?page=2", "last": "https://api/x?page=9"}
+//
+
+func (r *Response) Links() map[string]string {
+
+  links := make(map[string]string)
+
+  for _, header := range r.Header.Values("Link") {
+
+    for _, link := range strings.Split(header, ",") {
+
+      parts := strings.Split(link, ";")
+      target := strings.TrimSpace(parts[0])
+
+      if(!strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">")){
+        continue
+      }
+
+      target = strings.Trim(target, "<>")
+
+      for _, param := range parts[1:] {
+        key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+
+        if(!found || !strings.EqualFold(strings.TrimSpace(key), "rel")){
+          continue
+        }
+
+        for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+          links[rel] = target
+        }
+      }
+    }
+  }
+
+  return links
+}
+
+//
+// func (pRA *Restapi) GetLastResponse() *Response
+//
+// Returns the response from the last Send()/Do(), nil if there was none
+//
+
+func (pRA *Restapi) GetLastResponse() *Response {
+  return pRA.pLastResponse
+}
+
+//
+// func (pRA *Restapi) GetResponseHeaders() http.Header
+//
+// All headers from the last response, nil if there was none
+//
+
+func (pRA *Restapi) GetResponseHeaders() http.Header {
+
+  if(pRA.pLastResponse == nil){
+    return nil
+  }
+
+  return pRA.pLastResponse.Header
+}
+
+//
+// func (pRA *Restapi) GetResponseHeader(name string) string
+//
+// First value of a header from the last response (rate limits, ETag...)
+//
+// name - header name, not case sensitive
+//
+
+func (pRA *Restapi) GetResponseHeader(name string) string {
+  return pRA.GetResponseHeaders().Get(name)
+}
+
+//
+// func (pRA *Restapi) GetContentType() string
+//
+// Content-Type of the last response
+//
+
+func (pRA *Restapi) GetContentType() string {
+
+  if(pRA.pLastResponse == nil){
+    return ""
+  }
+
+  return pRA.pLastResponse.ContentType
+}
+
+//
+// func (pRA *Restapi) GetContentLength() int64
+//
+// Content-Length of the last response, -1 if unknown
+//
+
+func (pRA *Restapi) GetContentLength() int64 {
+
+  if(pRA.pLastResponse == nil){
+    return -1
+  }
+
+  return pRA.pLastResponse.ContentLength
+}
+
+//
+// func (pRA *Restapi) GetProto() string
+//
+// Protocol of the last response (HTTP/1.1, HTTP/2.0)
+//
+
+func (pRA *Restapi) GetProto() string {
+
+  if(pRA.pLastResponse == nil){
+    return ""
+  }
+
+  return pRA.pLastResponse.Proto
+}
+
+//
+// func (pRA *Restapi) GetFinalUrl() string
+//
+// The url the last response actually came from after redirects
+//
+
+func (pRA *Restapi) GetFinalUrl() string {
+
+  if(pRA.pLastResponse == nil){
+    return ""
+  }
+
+  return pRA.pLastResponse.FinalUrl
+}
@@ -15,11 +15,13 @@ package restapi
 import (
 	"fmt"
 	"bytes"
+	"context"
+	"errors"
 	"os"
 	"net/http"
+	"io"
 	"io/ioutil"
         "encoding/json"
-        "crypto/tls"
         "crypto/x509"
         "strings"
         "time"
@@ -39,6 +41,10 @@ const (
         Post
         Put
         Delete
+        Patch
+        Head
+        Options
+        Custom // any other verb - see NewMethod()
 )
 
 
@@ -49,6 +55,7 @@ const (
 type Restapi struct {
 
   sAccessToken         string
+  sApiKey              string
   sUrl                 string
   sName                string
   Method               HttpMethod
@@ -65,14 +72,44 @@ type Restapi struct {
   bXMLDontParseResponse      bool
 
   bJsonOnly                  bool // if true, we don't want the extra map help
+  bStrictDecode              bool // DecodeInto() rejects unknown fields
+  bUseNumber                 bool // DecodeInto() uses json.Number
+  bDecodeOnly                bool // SendInto() - skip RawData and the maps
 
   sCertFile                  string
   bUseCertFile               bool
-  pcaCertPool                  *x509.CertPool
 
   sJsonStr string
 
+  aBody       []byte     // see SetBodyJSON() etc
+  pBodyReader io.Reader  // see SetBodyReader()
+  pMultipart  *Multipart // see SetBodyMultipart()
+  bBodySent   bool       // pBodyReader has been used up
+  sBodyType   string     // Content-Type for the body
+
+  pClient                    *Client         // where the transport comes from
+  pTLSOptions                *TLSOptions     // overrides the client's when set
+  dTimeout                   time.Duration   // 0 means no timeout
+  nMaxBodySize               int64           // buffered body limit, 0 = none
+  sRange                     string          // Range header for resuming
+  sIfRange                   string          // If-Range sent with it
+  sLastEventID               string          // Last-Event-ID for Subscribe()
+  sAccept                    string          // Accept for Subscribe() unless SetHeader() gave one
+  fProgress                  ProgressFunc    // see OnProgress()
+  pRetryPolicy               *RetryPolicy    // nil means no retries
+  pTokenSource               TokenSource     // see SetTokenSource()
+  aAuthenticators            []Authenticator // see AddAuthenticator()
+  bNoClientAuth              bool            // don't use the client's auth
+  pSigner                    Signer          // see SetSigner()
+  bShowSecrets               bool            // don't redact Dump() etc
+
+  mAcceptedStatus            map[int]bool    // non 2xx codes that are not errors
+
+  hHeaders                   http.Header     // per request headers
+  mDelHeaders                map[string]bool // defaults not to send
+
   nLastStatusCode int
+  pLastResponse   *Response
 
   RawData interface{}  // used to contain the raw response msg mody
   BodyString string
@@ -134,33 +171,27 @@ func (pRA *Restapi) UseCert(certfile string) bool {
 //
 // FetchTLSCert queries the gateway and returns a copy of the TLS certificate
 // it is currently presenting for connections.  This is useful for saving and
-// later using with `SetTLSCert` to validate future connections.
+// later using with `PinCertificate` to validate future connections.
 //
 // Credits - this code came from https://github.com/foogod/go-powerwall/blob/main/client.go
 // 
-// Might have to rethink a bunch based on his more elegant use of go
+// url - https://host:port/..., host:port or host (port defaults to 443)
 //
+// See FetchTLSChain() for the full chain, SNI and timeout control
 //
 
 func (pRA *Restapi) FetchTLSCert(url string) (*x509.Certificate, bool) {
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: true,
-	}
-	conn, err := tls.Dial("tcp", url+":443", tlsConfig)
-	if err != nil {
-          msg := fmt.Sprintf("Error getting certificat", err)
-          logmsg.Print(logmsg.Error, msg)
-		return nil, false
-	}
-///fmt.Println(tlsConfig)
-	defer conn.Close()
-	certs := conn.ConnectionState().PeerCertificates
-///fmt.Println(certs)
 
-	return certs[0], true
-}
+  chain, err := FetchTLSChain(context.Background(), url, nil)
 
+  if err != nil {
+    msg := fmt.Sprintf("Error getting certificate: %s", err)
+    logmsg.Print(logmsg.Error, msg)
+    return nil, false
+  }
 
+  return chain.Certificates[0], true
+}
 
 //
 // func NewGet(name string, url string) *Restapi
@@ -225,6 +256,69 @@ func NewDelete(name string, url string) *Restapi{
   return(New(Delete, name, url))
 }
 
+//
+// func NewPatch(name string, url string) *Restapi
+//
+// Create a new restapi object for sending PATCH
+//
+// name - name of the patch
+// url - URL to execute against
+//
+//
+
+func NewPatch(name string, url string) *Restapi{
+  return(New(Patch, name, url))
+}
+
+//
+// func NewHead(name string, url string) *Restapi
+//
+// Create a new restapi object for sending HEAD.  No body is read
+// back so use the status code (and headers) for the answer
+//
+// name - name of the head
+// url - URL to execute against
+//
+//
+
+func NewHead(name string, url string) *Restapi{
+  return(New(Head, name, url))
+}
+
+//
+// func NewOptions(name string, url string) *Restapi
+//
+// Create a new restapi object for sending OPTIONS
+//
+// name - name of the options
+// url - URL to execute against
+//
+//
+
+func NewOptions(name string, url string) *Restapi{
+  return(New(Options, name, url))
+}
+
+//
+// func NewMethod(verb string, name string, url string) *Restapi
+//
+// Escape hatch for http verbs we don't have a constant for
+// (PROPFIND, MKCOL, etc).  The verb is sent exactly as given
+//
+// verb - http method to send
+// name - name of the cmd
+// url - URL to execute against
+//
+//
+
+func NewMethod(verb string, name string, url string) *Restapi{
+
+  r := New(Custom, name, url)
+  r.SetMethodString(verb)
+
+  return r
+}
+
 //
 // func New(method HttpMethod, name string, url string) *Restapi
 //
@@ -237,6 +331,16 @@ func NewDelete(name string, url string) *Restapi{
 //
 
 func New(method HttpMethod, name string, url string) *Restapi{
+  return(DefaultClient.New(method, name, url))
+}
+
+//
+// func newRestapi(method HttpMethod, name string, url string) *Restapi
+//
+// Sets up the restapi object, Client.New() attaches it to the client
+//
+
+func newRestapi(method HttpMethod, name string, url string) *Restapi{
 
 
   logmsg.Print(logmsg.Info, "In Restapi New")
@@ -263,6 +367,81 @@ func New(method HttpMethod, name string, url string) *Restapi{
   return r
 }
 
+//
+// func (pRA *Restapi) SetTimeout(timeout time.Duration)
+//
+// Sets the overall time limit for a request, covering connect, TLS
+// handshake, headers and reading the body.  Defaults to 10 seconds.
+//
+// timeout - time allowed, 0 means no limit (rely on the context)
+//
+
+func (pRA *Restapi) SetTimeout(timeout time.Duration){
+  pRA.dTimeout = timeout
+}
+
+//
+// func (pRA *Restapi) GetTimeout() time.Duration
+//
+// Returns the current request timeout
+//
+
+func (pRA *Restapi) GetTimeout() time.Duration{
+  return pRA.dTimeout
+}
+
+//
+// func (pRA *Restapi) AcceptStatus(codes ...int)
+//
+// Any 2xx status is a success.  Use this to declare other statuses as
+// expected answers rather than errors - e.g. 404 meaning "not found"
+// when checking if something exists.  The body is still read and
+// RawData filled in (if json), GetLastStatusCode() tells them apart
+//
+// codes - http status codes to accept
+//
+
+func (pRA *Restapi) AcceptStatus(codes ...int){
+
+  if(pRA.mAcceptedStatus == nil){
+    pRA.mAcceptedStatus = make(map[int]bool)
+  }
+
+  for _, code := range codes {
+    pRA.mAcceptedStatus[code] = true
+  }
+}
+
+//
+// func (pRA *Restapi) ClearAcceptedStatus()
+//
+// Back to only 2xx being a success
+//
+
+func (pRA *Restapi) ClearAcceptedStatus(){
+  pRA.mAcceptedStatus = nil
+}
+
+//
+// func (pRA *Restapi) IsAcceptedStatus(code int) bool
+//
+// True if code is a 2xx or was given to AcceptStatus()
+//
+
+func (pRA *Restapi) IsAcceptedStatus(code int) bool{
+  return isSuccess(code) || pRA.mAcceptedStatus[code]
+}
+
+//
+// func (pRA *Restapi) GetClient() *Client
+//
+// Returns the client this request sends over
+//
+
+func (pRA *Restapi) GetClient() *Client{
+  return pRA.pClient
+}
+
 //
 // func (pRA *Restapi) GetLastStatusCode() int
 //
@@ -451,18 +630,31 @@ func CastMap(item interface{}) map[string]interface{} {
 //
 // func (pRA *Restapi) Dump()
 //
-// For Diagnostics - dumps out the contents
+// For Diagnostics - dumps out the contents.  Credentials are masked
+// unless SetShowSecrets(true)
 //
 
 func (pRA *Restapi) Dump(){
 
+  r := pRA.redactor()
+
   fmt.Println("Dump:", pRA.sName)
-  fmt.Println("Url:", pRA.sUrl)
-  fmt.Println("Method:", pRA.Method)
+  fmt.Println("Url:", r.url(pRA.sUrl))
+  fmt.Println("Method:", int(pRA.Method))
   fmt.Println("MethodString:", pRA.sMethodString)
-  fmt.Println("AccessToken:", pRA.sAccessToken)
+  fmt.Println("AccessToken:", r.credential(pRA.sAccessToken))
+
+  if(pRA.bRequiresApiKey){
+    fmt.Println("ApiKey:", r.credential(pRA.sApiKey))
+  }
   fmt.Println("UseCert:", pRA.bUseCertFile)
-  fmt.Println("JsonStr:", pRA.sJsonStr)
+  contentType := pRA.GetHeader("Content-Type")
+
+  if(contentType == ""){
+    contentType = pRA.sBodyType // SetBodyForm() etc
+  }
+
+  fmt.Println("JsonStr:", r.body(contentType, pRA.sJsonStr))
 
   if(pRA.bUseCertFile){
     fmt.Println("sCertFile:",pRA.sCertFile)
@@ -472,14 +664,16 @@ func (pRA *Restapi) Dump(){
     fmt.Println("sInnerMapName:",pRA.sInnerMapName)
   }
 
+  // decoded responses hold access/refresh tokens after a login
+
   fmt.Println("ResponseMapData:")
-  for name, value := range pRA.mResponseMapData {
+  for name, value := range CastMap(r.value(pRA.mResponseMapData)) {
     fmt.Println(name, "=", value)
   }
 
   if( pRA.bInnerMap ){
     fmt.Println("InnerMapData:")
-    for k, v := range pRA.mInnerMapData {
+    for k, v := range CastMap(r.value(pRA.mInnerMapData)) {
       fmt.Println(k, "=", v)
 
     } // end for loop
@@ -492,7 +686,7 @@ func (pRA *Restapi) Dump(){
     for i:=0 ; i < pRA.iInnerMapArrayCount; i++ {
       //fmt.Println("Index:", i)
       //fmt.Println(pRA.amInnerMapArray[i])
-      tmpmap := CastMap(pRA.amInnerMapArray[i])
+      tmpmap := CastMap(r.value(pRA.amInnerMapArray[i]))
       for k, v := range tmpmap {
         fmt.Println("Index 0:", k, "=", v)
 
@@ -599,12 +793,13 @@ func (pRA *Restapi) HasInnerMapArray(name string, countname string){
 //
 // func (pRA *Restapi) SetApiKey(ApiKey string)
 //
-// Sets Api Key for Authentication
+// Sets Api Key for Authentication, sent as x-api-key.  Use
+// AddAuthenticator(APIKeyHeader(...)) for other header names
 //
 
 func (pRA *Restapi) SetApiKey(ApiKey string){
   //pRA.sAccessToken = fmt.Sprintf("X-API_KEY %s", ApiKey)
-  pRA.sAccessToken = ApiKey
+  pRA.sApiKey = ApiKey
   pRA.bRequiresApiKey = true
 }
 
@@ -662,24 +857,74 @@ func (pRA *Restapi) GetName() string{
 func (pRA *Restapi) setMethod(method HttpMethod){
 
   pRA.Method = method
+  pRA.sMethodString = method.String()
+
+}
+
+//
+// func (pRA *Restapi) SetMethodString(verb string)
+//
+// Overrides the http verb sent on the wire.  Method becomes Custom
+// unless the verb matches one of ours
+//
+// verb - http method, case sensitive (GET, PROPFIND, ...)
+//
+
+func (pRA *Restapi) SetMethodString(verb string){
+
+  pRA.Method = Custom
+  pRA.sMethodString = verb
+
+  for m := Get; m < Custom; m++ {
+    if(m.String() == verb){
+      pRA.Method = m
+    }
+  }
+}
+
+//
+// func (pRA *Restapi) GetMethodString() string
+//
+// Returns the http verb that will be sent
+//
+
+func (pRA *Restapi) GetMethodString() string{
+  return pRA.sMethodString
+}
+
+//
+// func (method HttpMethod) String() string
+//
+// The http verb for method, empty for Custom or unknown values
+//
+
+func (method HttpMethod) String() string{
 
   switch method {
     case Post:
-      pRA.sMethodString = "POST"
+      return http.MethodPost
 
     case Get:
-      pRA.sMethodString = "GET"
+      return http.MethodGet
 
     case Put:
-      pRA.sMethodString = "PUT"
+      return http.MethodPut
 
     case Delete:
-      pRA.sMethodString = "DELETE"
+      return http.MethodDelete
 
-    default:
-      pRA.sMethodString = "WHO KNOWS"
+    case Patch:
+      return http.MethodPatch
+
+    case Head:
+      return http.MethodHead
+
+    case Options:
+      return http.MethodOptions
 
   }
+
+  return ""
 }
 
 //
@@ -688,10 +933,14 @@ func (pRA *Restapi) setMethod(method HttpMethod){
 // Added this for dealing with known self signed certs.  
 // Otherwise https call will fail
 //
+// Deprecated: only affects DefaultClient now (it used to change
+// http.DefaultTransport for the whole program).  Use
+// Client.SetInsecureSkipVerify() or Restapi.SetInsecureSkipVerify()
+//
 
 func TurnOffCertValidation(){
 
-  http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+  DefaultClient.SetInsecureSkipVerify(true)
 
 }
 
@@ -701,10 +950,13 @@ func TurnOffCertValidation(){
 // Added this for dealing with known self signed certs.  
 // Otherwise https call will fail
 //
+// Deprecated: only affects DefaultClient now.  Use
+// Client.SetInsecureSkipVerify() or Restapi.SetInsecureSkipVerify()
+//
 
 func TurnOnCertValidation(){
 
-  http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: false}
+  DefaultClient.SetInsecureSkipVerify(false)
 
 }
 
@@ -817,91 +1069,216 @@ func (pRA *Restapi) SaveResponseBody(filename string, structname string, bstdout
 //
 
 func (pRA *Restapi) Send() bool {
+  return pRA.SendContext(context.Background())
+}
+
+//
+// func (pRA *Restapi) SendContext(ctx context.Context) bool
+//
+// Sends the API request, giving up when ctx is cancelled or its
+// deadline passes.  Cancellation is honored while connecting, during
+// the TLS handshake and while reading the body.
+//
+// ctx - context controlling the life of the request
+//
 
-  var req *http.Request
-  var tran *http.Transport
+func (pRA *Restapi) SendContext(ctx context.Context) bool {
 
-  tran = nil
+  _, err := pRA.DoContext(ctx)
 
-  if(len(pRA.sUrl) == 0){
-    msg := fmt.Sprintf("Send(%s): Url not set", pRA.sName)
-    logmsg.Print(logmsg.Error, msg)
+  if(err != nil){
+    logmsg.Print(logmsg.Error, err)
     if(pRA.bDebug){
-      fmt.Println(msg)
+      fmt.Println(err)
     }
     return false
   }
 
-  if(pRA.bDebug){
-    fmt.Println("URL:",pRA.sUrl)
-  }
+  return true
+}
 
-  if(!pRA.bHasPostJson){
-    req, _ = http.NewRequest(pRA.sMethodString, pRA.sUrl, nil)
-  }else{
-    req, _ = http.NewRequest(pRA.sMethodString, pRA.sUrl, 
-                              bytes.NewBufferString(pRA.sJsonStr))
+//
+// func (pRA *Restapi) Do() (*Response, error)
+//
+// Sends the API request and returns the response, or a typed error
+// (*TransportError, *StatusError, *DecodeError, *CertError) explaining
+// why it failed.  Use errors.Is against ErrTransport, ErrStatus, etc
+// or errors.As to get at the details
+//
+
+func (pRA *Restapi) Do() (*Response, error) {
+  return pRA.DoContext(context.Background())
+}
+
+//
+// func (pRA *Restapi) DoContext(ctx context.Context) (*Response, error)
+//
+// Same as Do() but bound to ctx
+//
+// ctx - context controlling the life of the request
+//
+
+func (pRA *Restapi) DoContext(ctx context.Context) (*Response, error) {
+  return pRA.doLoop(ctx, pRA.doOnce)
+}
+
+//
+// func (pRA *Restapi) doLoop(ctx, once) (*Response, error)
+//
+// Runs once until it works, the 401 handling and retry policy say
+// stop, or ctx is done
+//
+
+func (pRA *Restapi) doLoop(ctx context.Context,
+                           once func(context.Context) (*Response, error)) (*Response, error) {
+
+  pRA.resetResponse()
+
+  if(len(pRA.sUrl) == 0){
+    return nil, fmt.Errorf("Send(%s): %w", pRA.sName, ErrNoUrl)
   }
 
-  if(pRA.bRequiresAccessToken){
-    req.Header.Add("Authorization", pRA.sAccessToken)
+  if(len(pRA.sMethodString) == 0){
+    return nil, fmt.Errorf("Send(%s): %w [%d]", pRA.sName, ErrMethod, pRA.Method)
   }
 
-  if(pRA.bRequiresApiKey){
-    req.Header.Add("x-api-key", pRA.sAccessToken)
+  bAuthRetried := false
+
+  for attempt := 1; ; attempt++ {
+
+    resp, err := once(ctx)
+
+    // a 401 may just mean a token was revoked or expired early, or a
+    // challenge needs answering - let the authenticators sort it out
+    // and try once more
+
+    if(!bAuthRetried && pRA.canResend() && pRA.challenge(resp)){
+      bAuthRetried = true
+      attempt--
+      continue
+    }
+
+    delay, retry := pRA.shouldRetry(ctx, attempt, err)
+
+    if(!retry){
+      return resp, err
+    }
+
+    msg := fmt.Sprintf("Send(%s): attempt %d failed, retrying in %s - %s",
+                       pRA.sName, attempt, delay, err)
+    logmsg.Print(logmsg.Warning, msg)
+
+    if(!sleepContext(ctx, delay)){
+      return resp, err
+    }
   }
 
-//  req.Header.Add("Accept", "*/*")
+}
 
-  req.Header.Add("cache-control", "no-cache")
-  req.Header.Add("Content-Type", "application/json")
+//
+// func (pRA *Restapi) doOnce(ctx context.Context) (*Response, error)
+//
+// A single attempt at the request
+//
 
+func (pRA *Restapi) doOnce(ctx context.Context) (*Response, error) {
 
-//fmt.Println(req)
+  res, resp, err := pRA.roundTrip(ctx, false)
 
-  
+  if(err != nil){
+    return resp, err
+  }
 
-  if(pRA.bUseCertFile){
-    caCert, err := ioutil.ReadFile(pRA.sCertFile)
+  defer res.Body.Close()
 
-    if(err != nil){
-      msg := fmt.Sprintf("Error reading cert file[%s] - %s", pRA.sCertFile, err)
-      logmsg.Print(logmsg.Error, msg)
-      return false 
+  // HEAD never has a body - nothing to read or decode
+
+  if(pRA.Method == Head){
+    if(!pRA.IsAcceptedStatus(res.StatusCode)){
+      return resp, pRA.statusError(resp)
     }
+    return resp, nil
+  }
 
-    pRA.pcaCertPool = x509.NewCertPool()
-    pRA.pcaCertPool.AppendCertsFromPEM(caCert)
+  body, err := pRA.readBody(res)
 
+  if(err != nil){
+    return resp, pRA.transportError(err)
+  }
 
-    tran = &http.Transport{ TLSClientConfig: &tls.Config{ RootCAs: pRA.pcaCertPool } }
+  resp.Body = body
 
-  } // end if use a certfile
+  pRA.BodyBytes = body
+  pRA.BodyString = string(body) // save this off - even on errors
 
+  if(pRA.bDebug){
+    pRA.debugResponse(res)
+    fmt.Println(pRA.redactor().body(res.Header.Get("Content-Type"), string(body)))
+  }
+
+  if(!pRA.IsAcceptedStatus(res.StatusCode)){
+    return resp, pRA.statusError(resp)
+  }
+
+  // an expected non 2xx (404 as "not found" etc) still gets RawData
+  // but is not held to the inner map layout of a real answer
+
+  if(pRA.bDecodeOnly){
+    return resp, nil // the caller decodes into its own type
+  }
+
+  err = pRA.decodeBody(body, isSuccess(res.StatusCode))
+
+  resp.RawData = pRA.RawData
+
+  return resp, err
+}
+
+//
+// func (pRA *Restapi) roundTrip(ctx context.Context, stream bool) (*http.Response, *Response, error)
+//
+// Sends the request and returns once the response headers are in -
+// the body is left for the caller to read and close
+//
+// stream - true to not apply the timeout to reading the body
+//
+
+func (pRA *Restapi) roundTrip(ctx context.Context, stream bool) (*http.Response, *Response, error) {
+
+  pRA.resetResponse()
 
   if(pRA.bDebug){
-    fmt.Println(req)
+    fmt.Println("URL:", pRA.redactor().url(pRA.sUrl))
   }
 
-//  var netClient = &http.Client{Timeout: time.Second * 10, }
-  var netClient *http.Client
+  req, err := pRA.newRequest(ctx)
 
-  if(tran == nil){
-    netClient = &http.Client{Timeout: time.Second * 10, }
-  }else{
-    netClient = &http.Client{Transport: tran, Timeout: time.Second * 10, }
+  if(err != nil){
+    return nil, nil, err
+  }
+
+  netClient, err := pRA.netClient()
+
+  if(err != nil){
+    pRA.abandonBody(req.Body)
+    return nil, nil, err
+  }
+
+  if(stream){
+    netClient.Timeout = 0 // a download can take as long as it takes
+  }
+
+  if(pRA.bDebug){
+    pRA.debugRequest(req)
   }
 
-  //res, err := http.DefaultClient.Do(req)
   res, err := netClient.Do(req)
 
-  if(res == nil){
-    //logmsg.Print(logmsg.Error, "Error getting to server at URL:", pRA.sUrl)
-    logmsg.Print(logmsg.Error, "Error:", err)
+  if(err != nil){
     if(pRA.bDebug){
-      fmt.Println("Error getting to server at URL:", pRA.sUrl)
+      fmt.Println("Error getting to server at URL:", pRA.redactor().url(pRA.sUrl))
     }
-    return false
+    return nil, nil, pRA.transportError(err)
   }
 
   if(pRA.bDebug){
@@ -910,31 +1287,180 @@ func (pRA *Restapi) Send() bool {
 
   pRA.nLastStatusCode = res.StatusCode
 
-  switch res.StatusCode {
+  resp := newResponse(res)
 
-    case 200:
-    case 201:
+  pRA.pLastResponse = resp
 
-    default:
-      logmsg.Print(logmsg.Error,"HTTP Response Status:", res.StatusCode, http.StatusText(res.StatusCode))
-      logmsg.Print(logmsg.Error,pRA.sUrl)
-      return false
+  return res, resp, nil
+}
 
+//
+// func (pRA *Restapi) readBody(res *http.Response) ([]byte, error)
+//
+// Reads the whole body, refusing anything over SetMaxBodySize()
+//
+
+func (pRA *Restapi) readBody(res *http.Response) ([]byte, error) {
+
+  max := pRA.nMaxBodySize
+
+  if(max <= 0){
+    return ioutil.ReadAll(res.Body)
   }
 
+  if(res.ContentLength > max){
+    return nil, fmt.Errorf("%w: %d bytes, limit is %d", ErrBodyTooLarge, res.ContentLength, max)
+  }
 
-  defer res.Body.Close()
-  body, _ := ioutil.ReadAll(res.Body)
+  body, err := ioutil.ReadAll(io.LimitReader(res.Body, max + 1))
 
-  pRA.BodyBytes = body
-  pRA.BodyString = string(body) // save this off
+  if(err != nil){
+    return nil, err
+  }
 
+  if(int64(len(body)) > max){
+    return nil, fmt.Errorf("%w: limit is %d bytes", ErrBodyTooLarge, max)
+  }
 
-  if(pRA.bDebug){
-    fmt.Println(res)
-    fmt.Println(string(body))
+  return body, nil
+}
+
+//
+// func (pRA *Restapi) newRequest(ctx context.Context) (*http.Request, error)
+//
+// Builds the http request with our headers, authentication and signature
+//
+
+func (pRA *Restapi) newRequest(ctx context.Context) (*http.Request, error) {
+
+  body, err := pRA.bodyReader()
+
+  if(err != nil){
+    return nil, pRA.transportError(err)
   }
 
+  req, err := pRA.buildRequest(ctx, body)
+
+  if(err != nil){
+    pRA.abandonBody(body)
+    return nil, err
+  }
+
+  return req, nil
+}
+
+//
+// func (pRA *Restapi) buildRequest(ctx context.Context, body io.Reader) (*http.Request, error)
+//
+// newRequest() once the body is ready
+//
+
+func (pRA *Restapi) buildRequest(ctx context.Context, body io.Reader) (*http.Request, error) {
+
+  req, err := http.NewRequestWithContext(ctx, pRA.sMethodString, pRA.sUrl, body)
+
+  if(err != nil){
+    return nil, pRA.transportError(err)
+  }
+
+  if n := pRA.bodyLength(); n >= 0 {
+    req.ContentLength = n
+  }
+
+  pRA.applyHeaders(req)
+
+  // SetHeader() wins, and DelHeader() means send none at all
+
+  if(pRA.sBodyType != "" && pRA.GetHeader("Content-Type") == "" && !pRA.mDelHeaders["Content-Type"]){
+    req.Header.Set("Content-Type", pRA.sBodyType)
+  }
+
+  if(pRA.sRange != ""){
+    req.Header.Set("Range", pRA.sRange)
+
+    if(pRA.sIfRange != ""){
+      req.Header.Set("If-Range", pRA.sIfRange)
+    }
+  }
+
+  if(pRA.sAccept != "" && pRA.GetHeader("Accept") == "" && !pRA.mDelHeaders["Accept"]){
+    req.Header.Set("Accept", pRA.sAccept)
+  }
+
+  if(pRA.sLastEventID != ""){
+    req.Header.Set("Last-Event-ID", pRA.sLastEventID)
+  }
+
+  err = pRA.authenticate(req)
+
+  if(err != nil){
+    return nil, err
+  }
+
+  err = pRA.sign(req)
+
+  if(err != nil){
+    return nil, err
+  }
+
+  return req, nil
+}
+
+//
+// func (pRA *Restapi) netClient() (*http.Client, error)
+//
+// Returns the http client to send with.  The transport is shared from
+// our Client so connections get reused - one trusting the certificate
+// file if UseCert() was called, one per set of TLS options for requests
+// with their own
+//
+
+func (pRA *Restapi) netClient() (*http.Client, error) {
+
+  if(pRA.pTLSOptions != nil){
+
+    certfile := ""
+
+    if(pRA.bUseCertFile){
+      certfile = pRA.sCertFile
+    }
+
+    tran, err := pRA.pClient.tlsTransport(pRA.pTLSOptions, certfile)
+
+    if(err != nil){
+      return nil, err
+    }
+
+    return &http.Client{Transport: tran, Timeout: pRA.dTimeout, }, nil
+  }
+
+  tran := pRA.pClient.transport()
+
+  if(pRA.bUseCertFile){
+    var err error
+
+    tran, err = pRA.pClient.certTransport(pRA.sCertFile)
+
+    if(err != nil){
+      return nil, err
+    }
+
+  } // end if use a certfile
+
+  return &http.Client{Transport: tran, Timeout: pRA.dTimeout, }, nil
+}
+
+//
+// func (pRA *Restapi) decodeBody(body []byte, buildMaps bool) error
+//
+// Turns the body into RawData and builds the response/inner maps
+//
+// body - response body
+// buildMaps - false to stop once RawData is set
+//
+
+func (pRA *Restapi) decodeBody(body []byte, buildMaps bool) error {
+
 //
 // added xml logic 9/8/2019 
 // using the xml2jon library from github we move the xml into json
@@ -943,18 +1469,15 @@ func (pRA *Restapi) Send() bool {
   if(pRA.bXML){
     if(!pRA.bXMLDontParseResponse){
       pRA.RawData = string(body) // need to figure out how to save
-      return true
+      return nil
     }
 
-/////fmt.Println(string(body))
-
     xml := strings.NewReader(string(body))
 
     ejson, err := xml2json.Convert(xml)
     if err != nil {
-  	panic("That's embarrassing...")
+      return &DecodeError{ Name: pRA.sName, Format: "xml", Err: err, Body: body }
     }
-/////fmt.Println(ejson.String())
 
    // reusing the body variable so we can fall through to exising logic
    // pre-xml code added
@@ -963,20 +1486,23 @@ func (pRA *Restapi) Send() bool {
 
   }
 
-  json.Unmarshal(body, &pRA.RawData)
-
-/////fmt.Println(pRA.RawData)
-/////os.Exit(1)
+  if(len(body) > 0){
+    if err := json.Unmarshal(body, &pRA.RawData); err != nil {
+      // not everything that comes back is json - leave RawData nil
+      // and let the caller use GetResponseBody()
+      logmsg.Print(logmsg.Warning, "Response is not json:", err)
+    }
+  }
 
   if(pRA.bDebug){
-    fmt.Println(pRA.RawData)
+    fmt.Println(pRA.redactor().value(pRA.RawData))
   }
 
   if(pRA.RawData == nil){
 
     logmsg.Print(logmsg.Warning,"No data returned")
 
-    return true
+    return nil
   }
 
   // This test is because some of the processing below still does 
@@ -989,36 +1515,106 @@ func (pRA *Restapi) Send() bool {
   if(pRA.bJsonOnly){
 
     logmsg.Print(logmsg.Warning,"bJsonOnly set - data not fully processed")
-    return true
+    return nil
+
+  }
 
+  if(!buildMaps){
+    return nil
   }
 
-  pRA.mResponseMapData = CastMap(pRA.RawData)
+  var ok bool
+
+  pRA.mResponseMapData, ok = pRA.RawData.(map[string]interface{})
+
+  if(!ok){
+    return pRA.decodeError("response is not a json object")
+  }
 
   if(pRA.bInnerMap){
     if(pRA.bDebug){
       fmt.Println("Looking for innermap:", pRA.sInnerMapName)
     }
-    pRA.mInnerMapData = CastMap(pRA.mResponseMapData[pRA.sInnerMapName])
+    pRA.mInnerMapData, ok = pRA.mResponseMapData[pRA.sInnerMapName].(map[string]interface{})
+
+    if(!ok){
+      return pRA.decodeError(fmt.Sprintf("inner map [%s] not found", pRA.sInnerMapName))
+    }
   }else if(pRA.bInnerMapArray){
-    tmp1 := pRA.mResponseMapData[pRA.sInnerMapName]
-    pRA.amInnerMapArray = CastArray(tmp1)
+    pRA.amInnerMapArray, ok = pRA.mResponseMapData[pRA.sInnerMapName].([]interface{})
 
-    var f float64
-    f = pRA.mResponseMapData[pRA.sInnerMapArrayCountName].(float64)
+    if(!ok){
+      return pRA.decodeError(fmt.Sprintf("inner map array [%s] not found", pRA.sInnerMapName))
+    }
+
+    f, ok := pRA.mResponseMapData[pRA.sInnerMapArrayCountName].(float64)
+
+    if(!ok){
+      return pRA.decodeError(fmt.Sprintf("array count [%s] not found", pRA.sInnerMapArrayCountName))
+    }
 
     pRA.iInnerMapArrayCount = int(f)
 
   }
 
+  return nil
 
-  return true
+}
+
+//
+// func (pRA *Restapi) resetResponse()
+//
+// Clears out anything left over from a previous send
+//
+
+func (pRA *Restapi) resetResponse() {
+
+  pRA.nLastStatusCode = 0
+  pRA.pLastResponse = nil
+
+  pRA.RawData = nil
+  pRA.BodyString = ""
+  pRA.BodyBytes = nil
+
+  pRA.mResponseMapData = nil
+  pRA.mInnerMapData = nil
+  pRA.amInnerMapArray = nil
+  pRA.iInnerMapArrayCount = 0
+}
 
+func (pRA *Restapi) transportError(err error) error {
+  r := pRA.redactor()
 
+  return &TransportError{ Name: pRA.sName, Method: pRA.sMethodString,
+                          Url: r.url(pRA.sUrl), Err: r.error(err) }
+}
+
+func (pRA *Restapi) statusError(resp *Response) error {
+  return &StatusError{ Name: pRA.sName, Method: pRA.sMethodString,
+                       Url: pRA.redactor().url(pRA.sUrl), StatusCode: resp.StatusCode,
+                       Status: resp.Status, Header: resp.Header,
+                       Body: resp.Body }
+}
+
+//
+// func isSuccess(code int) bool
+//
+// Any 2xx is a success
+//
+
+func isSuccess(code int) bool {
+  return code >= 200 && code <= 299
+}
+
+func (pRA *Restapi) decodeError(msg string) error {
+  return &DecodeError{ Name: pRA.sName, Format: "json",
+                       Err: errors.New(msg), Body: pRA.BodyBytes }
 }
 
 func (pRA *Restapi) SetPostJson(jsonstr string) bool {
 
+  pRA.clearBody()
+
   pRA.bHasPostJson = true
   pRA.sJsonStr = jsonstr
 
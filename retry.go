@@ -0,0 +1,318 @@
+//
+//
+// restapi - retry
+//
+// Optional retry of failed requests with exponential backoff and
+// jitter.  Added for the Tesla and Powerwall apis which like to hand
+// back 408/429/502/503/504 or drop the connection now and then
+//
+// Only idempotent methods (GET, HEAD, PUT, DELETE, OPTIONS) are retried
+// unless RetryNonIdempotent is set in the policy
+//
+//
+
+package restapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+type RetryPolicy struct {
+
+  MaxAttempts int           // total tries, including the first one
+
+  BaseDelay time.Duration   // delay before the first retry
+  MaxDelay  time.Duration   // backoff never grows past this (0 = no cap)
+  Jitter    float64         // 0.0 - 1.0, fraction of the delay randomized
+
+  RetryStatuses []int       // http status codes worth another try
+
+  RetryTransportErrors bool // retry connection resets, refused, timeouts
+  RetryNonIdempotent   bool // allow POST/PATCH to be retried
+  RespectRetryAfter    bool // use the server's Retry-After when sent - one
+                            // longer than MaxDelay is not retried
+
+}
+
+//
+// func DefaultRetryPolicy() *RetryPolicy
+//
+// Returns a policy of 3 attempts, 500ms doubling up to 30s with 50%
+// jitter, retrying 408/429/502/503/504 and transient network errors
+//
+
+func DefaultRetryPolicy() *RetryPolicy {
+
+  return &RetryPolicy{ MaxAttempts: 3,
+                       BaseDelay: 500 * time.Millisecond,
+                       MaxDelay: 30 * time.Second,
+                       Jitter: 0.5,
+                       RetryStatuses: []int{ http.StatusRequestTimeout,
+                                             http.StatusTooManyRequests,
+                                             http.StatusBadGateway,
+                                             http.StatusServiceUnavailable,
+                                             http.StatusGatewayTimeout },
+                       RetryTransportErrors: true,
+                       RetryNonIdempotent: false,
+                       RespectRetryAfter: true }
+}
+
+//
+// func (pRA *Restapi) SetRetryPolicy(policy *RetryPolicy)
+//
+// Turns on retries for this request.  Pass nil to turn them back off
+//
+// policy - see DefaultRetryPolicy() for a good starting point
+//
+
+func (pRA *Restapi) SetRetryPolicy(policy *RetryPolicy){
+  pRA.pRetryPolicy = policy
+}
+
+//
+// func (pRA *Restapi) GetRetryPolicy() *RetryPolicy
+//
+// Returns the current retry policy, nil if retries are off
+//
+
+func (pRA *Restapi) GetRetryPolicy() *RetryPolicy{
+  return pRA.pRetryPolicy
+}
+
+//
+// func (p *RetryPolicy) Backoff(attempt int) time.Duration
+//
+// Returns the delay to wait after the given (1 based) failed attempt
+//
+
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+
+  delay := p.BaseDelay
+
+  for i := 1; i < attempt; i++ {
+    delay *= 2
+
+    if(p.MaxDelay > 0 && delay >= p.MaxDelay){
+      break
+    }
+
+    if(delay <= 0){ // overflow
+      delay = p.MaxDelay
+      break
+    }
+  }
+
+  if(p.MaxDelay > 0 && delay > p.MaxDelay){
+    delay = p.MaxDelay
+  }
+
+  if(p.Jitter > 0 && delay > 0){
+    jitter := p.Jitter
+
+    if(jitter > 1){
+      jitter = 1
+    }
+
+    delay -= time.Duration(rand.Float64() * jitter * float64(delay))
+  }
+
+  return delay
+}
+
+//
+// func (p *RetryPolicy) retryableStatus(code int) bool
+//
+// Is code one of the statuses we retry on
+//
+
+func (p *RetryPolicy) retryableStatus(code int) bool {
+
+  for _, c := range p.RetryStatuses {
+    if(c == code){
+      return true
+    }
+  }
+
+  return false
+}
+
+//
+// func (pRA *Restapi) shouldRetry(ctx, attempt, err) (time.Duration, bool)
+//
+// Decides if a failed attempt is worth trying again and how long to
+// wait before doing so
+//
+
+func (pRA *Restapi) shouldRetry(ctx context.Context, attempt int,
+                                err error) (time.Duration, bool) {
+
+  p := pRA.pRetryPolicy
+
+  if(err == nil || p == nil || attempt >= p.MaxAttempts){
+    return 0, false
+  }
+
+  if(ctx.Err() != nil){
+    return 0, false
+  }
+
+  if(!p.RetryNonIdempotent && !isIdempotent(pRA.sMethodString)){
+    return 0, false
+  }
+
+  if(!pRA.canResend()){
+    return 0, false
+  }
+
+  var statusErr *StatusError
+
+  if(errors.As(err, &statusErr)){
+
+    if(!p.retryableStatus(statusErr.StatusCode)){
+      return 0, false
+    }
+
+    if(p.RespectRetryAfter){
+      if d, ok := parseRetryAfter(statusErr.Header.Get("Retry-After")); ok {
+
+        // told to come back later than we're willing to wait - hand the
+        // 429/503 back rather than sleep for hours
+
+        if(p.MaxDelay > 0 && d > p.MaxDelay){
+          return 0, false
+        }
+
+        return d, true
+      }
+    }
+
+    return p.Backoff(attempt), true
+  }
+
+  if(p.RetryTransportErrors && errors.Is(err, ErrTransport) && isTransientError(err)){
+    return p.Backoff(attempt), true
+  }
+
+  return 0, false
+}
+
+func isIdempotent(method string) bool {
+
+  switch method {
+    case http.MethodGet, http.MethodHead, http.MethodPut,
+         http.MethodDelete, http.MethodOptions, http.MethodTrace:
+      return true
+  }
+
+  return false
+}
+
+//
+// func isTransientError(err error) bool
+//
+// Connection level failures that may well work the next time.  Things
+// like a bad certificate or an unknown host are not going to fix
+// themselves so are not retried
+//
+
+func isTransientError(err error) bool {
+
+  if(errors.Is(err, context.Canceled) || errors.Is(err, ErrPinMismatch)){
+    return false
+  }
+
+  var certErr *tls.CertificateVerificationError
+  var unknownAuth x509.UnknownAuthorityError
+  var hostErr x509.HostnameError
+  var invalidErr x509.CertificateInvalidError
+
+  if(errors.As(err, &certErr) || errors.As(err, &unknownAuth) ||
+     errors.As(err, &hostErr) || errors.As(err, &invalidErr)){
+    return false
+  }
+
+  var dnsErr *net.DNSError
+
+  if(errors.As(err, &dnsErr)){
+    return dnsErr.IsTemporary || dnsErr.IsTimeout
+  }
+
+  if(errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+     errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+     errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE)){
+    return true
+  }
+
+  var netErr net.Error
+
+  if(errors.As(err, &netErr) && netErr.Timeout()){
+    return true
+  }
+
+  var opErr *net.OpError
+
+  return errors.As(err, &opErr)
+}
+
+//
+// func parseRetryAfter(value string) (time.Duration, bool)
+//
+// Retry-After is either a number of seconds or an http date
+//
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+
+  if(value == ""){
+    return 0, false
+  }
+
+  if secs, err := strconv.Atoi(value); err == nil {
+    if(secs < 0){
+      return 0, false
+    }
+    return time.Duration(secs) * time.Second, true
+  }
+
+  when, err := http.ParseTime(value)
+
+  if(err != nil){
+    return 0, false
+  }
+
+  d := time.Until(when)
+
+  if(d < 0){
+    d = 0
+  }
+
+  return d, true
+}
+
+//
+// func sleepContext(ctx context.Context, d time.Duration) bool
+//
+// Waits for d, returns false if ctx was cancelled first
+//
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+
+  t := time.NewTimer(d)
+  defer t.Stop()
+
+  select {
+    case <-ctx.Done():
+      return false
+    case <-t.C:
+      return true
+  }
+}
@@ -0,0 +1,69 @@
+package restapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterHonoured(t *testing.T) {
+
+	var hits atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	r := NewGet("retry", srv.URL)
+	r.SetRetryPolicy(DefaultRetryPolicy())
+
+	if _, err := r.Do(); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	if hits.Load() != 2 {
+		t.Fatalf("hits = %d, want 2", hits.Load())
+	}
+}
+
+func TestRetryAfterBeyondMaxDelay(t *testing.T) {
+
+	var hits atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Retry-After", "86400")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	r := NewGet("retry", srv.URL)
+	r.SetRetryPolicy(DefaultRetryPolicy())
+
+	start := time.Now()
+
+	_, err := r.Do()
+
+	var statusErr *StatusError
+
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("err = %v, want a 429 StatusError", err)
+	}
+
+	if hits.Load() != 1 {
+		t.Fatalf("hits = %d, want 1", hits.Load())
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("waited %s", time.Since(start))
+	}
+}
@@ -0,0 +1,394 @@
+//
+//
+// restapi - request signing
+//
+// A Signer signs the finished request - method, url, headers and body
+// hash - after the headers and authenticators have been applied, so the
+// signature covers everything that goes on the wire.  Each retry is
+// signed again
+//
+//   r.SetSigner(restapi.NewAWSSigner(key, secret, "us-east-1", "s3"))
+//
+// Built in: AWS Signature V4 (AWSSigner) and a generic HMAC-SHA256
+// (HMACSigner) for webhook style partner apis
+//
+//
+
+package restapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Signer interface {
+  Sign(req *http.Request, body []byte) error
+}
+
+//
+// A Signer that doesn't always hash the body (AWSSigner with
+// UnsignedPayload) says so here.  When SignsPayload() is false it is
+// handed a nil body, so streamed bodies that can only be read once
+// (SetBodyReader(), multipart files) can still be signed
+//
+
+type PayloadSigner interface {
+  SignsPayload() bool
+}
+
+//
+// SignerFunc lets a plain function be used as a Signer
+//
+
+type SignerFunc func(req *http.Request, body []byte) error
+
+func (f SignerFunc) Sign(req *http.Request, body []byte) error {
+  return f(req, body)
+}
+
+//
+// Builds the string an HMACSigner signs.  ts is the timestamp sent in
+// the TimestampHeader (empty if none)
+//
+
+type HMACCanonicalizer func(req *http.Request, body []byte, ts string) string
+
+type HMACSigner struct {
+
+  Key []byte
+
+  KeyID       string // sent in KeyIDHeader when set
+  KeyIDHeader string
+
+  SignatureHeader string // where the signature goes
+  SignaturePrefix string // e.g. "sha256=" for GitHub style webhooks
+  Base64          bool   // base64 rather than hex
+
+  TimestampHeader string // unix seconds sent (and signed) here, "" for none
+
+  SignedHeaders []string // headers included by CanonicalRequest
+
+  Canonicalize HMACCanonicalizer // nil = HMACSigner.CanonicalRequest
+
+  Now func() time.Time // nil = time.Now
+
+}
+
+//
+// func NewHMACSigner(key []byte) *HMACSigner
+//
+// HMAC-SHA256 signer sending X-Signature (hex) and X-Timestamp, over
+// the canonical request (see CanonicalRequest).  Change the fields for
+// other apis
+//
+
+func NewHMACSigner(key []byte) *HMACSigner {
+
+  return &HMACSigner{ Key: key,
+                      KeyIDHeader: "X-Key-Id",
+                      SignatureHeader: "X-Signature",
+                      TimestampHeader: "X-Timestamp" }
+}
+
+//
+// func (s *HMACSigner) Sign(req *http.Request, body []byte) error
+//
+// Sets the timestamp, key id and signature headers
+//
+
+func (s *HMACSigner) Sign(req *http.Request, body []byte) error {
+
+  if(len(s.Key) == 0){
+    return fmt.Errorf("hmac signer: no key")
+  }
+
+  if(s.SignatureHeader == ""){
+    return fmt.Errorf("hmac signer: no signature header")
+  }
+
+  ts := ""
+
+  if(s.TimestampHeader != ""){
+    ts = strconv.FormatInt(signerNow(s.Now).Unix(), 10)
+    req.Header.Set(s.TimestampHeader, ts)
+  }
+
+  if(s.KeyID != "" && s.KeyIDHeader != ""){
+    req.Header.Set(s.KeyIDHeader, s.KeyID)
+  }
+
+  canonicalize := s.Canonicalize
+
+  if(canonicalize == nil){
+    canonicalize = s.CanonicalRequest
+  }
+
+  sum := hmacSHA256(s.Key, canonicalize(req, body, ts))
+
+  sig := hex.EncodeToString(sum)
+
+  if(s.Base64){
+    sig = base64.StdEncoding.EncodeToString(sum)
+  }
+
+  req.Header.Set(s.SignatureHeader, s.SignaturePrefix + sig)
+
+  return nil
+}
+
+//
+// func (s *HMACSigner) CanonicalRequest(req *http.Request, body []byte, ts string) string
+//
+// The default string to sign, one item per line:
+//
+//   METHOD
+//   /escaped/path
+//   sorted=query&string=
+//   timestamp
+//   lowercased-name:value   (one line per SignedHeaders entry)
+//   hex sha256 of the body
+//
+
+func (s *HMACSigner) CanonicalRequest(req *http.Request, body []byte, ts string) string {
+
+  lines := []string{ req.Method,
+                     canonicalPath(req.URL, false),
+                     canonicalQuery(req.URL),
+                     ts }
+
+  for _, name := range s.SignedHeaders {
+    lines = append(lines, strings.ToLower(name) + ":" + canonicalHeaderValue(req, name))
+  }
+
+  lines = append(lines, sha256Hex(body))
+
+  return strings.Join(lines, "\n")
+}
+
+//
+// func HMACBody(req *http.Request, body []byte, ts string) string
+//
+// Canonicalizer that signs just the body - GitHub/Stripe style webhooks.
+// With a timestamp it signs "ts.body"
+//
+
+func HMACBody(req *http.Request, body []byte, ts string) string {
+
+  if(ts == ""){
+    return string(body)
+  }
+
+  return ts + "." + string(body)
+}
+
+//
+// func (c *Client) SetSigner(s Signer)
+//
+// Signs every request from this client.  nil to stop
+//
+
+func (c *Client) SetSigner(s Signer){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.pSigner = s
+}
+
+//
+// func (pRA *Restapi) SetSigner(s Signer)
+//
+// Signs this request, in place of any client signer
+//
+
+func (pRA *Restapi) SetSigner(s Signer){
+  pRA.pSigner = s
+}
+
+//
+// func (pRA *Restapi) sign(req *http.Request) error
+//
+// Runs the request's (or client's) signer over the finished request
+//
+
+func (pRA *Restapi) sign(req *http.Request) error {
+
+  s := pRA.pSigner
+
+  if(s == nil && !pRA.bNoClientAuth){
+    pRA.pClient.mu.Lock()
+    s = pRA.pClient.pSigner
+    pRA.pClient.mu.Unlock()
+  }
+
+  if(s == nil){
+    return nil
+  }
+
+  var body []byte
+  var err error
+
+  if ps, ok := s.(PayloadSigner); !ok || ps.SignsPayload() {
+    body, err = requestBody(req)
+  }
+
+  if(err == nil){
+    err = s.Sign(req, body)
+  }
+
+  if(err != nil){
+    return &AuthError{ Name: pRA.sName, Err: err }
+  }
+
+  return nil
+}
+
+func signerNow(now func() time.Time) time.Time {
+
+  if(now == nil){
+    return time.Now()
+  }
+
+  return now()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+
+  mac := hmac.New(sha256.New, key)
+  mac.Write([]byte(data))
+
+  return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+
+  sum := sha256.Sum256(data)
+
+  return hex.EncodeToString(sum[:])
+}
+
+//
+// func uriEncode(s string, keepSlash bool) string
+//
+// RFC 3986 encoding - everything but A-Z a-z 0-9 - _ . ~ escaped
+//
+
+func uriEncode(s string, keepSlash bool) string {
+
+  var b strings.Builder
+
+  for i := 0; i < len(s); i++ {
+    c := s[i]
+
+    switch {
+      case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+           c == '-', c == '_', c == '.', c == '~':
+        b.WriteByte(c)
+      case c == '/' && keepSlash:
+        b.WriteByte(c)
+      default:
+        fmt.Fprintf(&b, "%%%02X", c)
+    }
+  }
+
+  return b.String()
+}
+
+//
+// func canonicalPath(u *url.URL, double bool) string
+//
+// The uri encoded path, "/" if empty.  Built from the path as sent, a
+// segment at a time, so an escaped / (%2F) in a key stays escaped
+// rather than becoming a separator.  double encodes it twice (what
+// SigV4 wants for everything except S3)
+//
+
+func canonicalPath(u *url.URL, double bool) string {
+
+  segments := strings.Split(u.EscapedPath(), "/")
+
+  for i, seg := range segments {
+    if raw, err := url.PathUnescape(seg); err == nil {
+      seg = raw
+    }
+    segments[i] = uriEncode(seg, false)
+  }
+
+  path := strings.Join(segments, "/")
+
+  if(path == ""){
+    path = "/"
+  }
+
+  if(double){
+    path = uriEncode(path, true)
+  }
+
+  return path
+}
+
+//
+// func canonicalQuery(u *url.URL) string
+//
+// Query parameters uri encoded and sorted by name then value
+//
+
+func canonicalQuery(u *url.URL) string {
+
+  var pairs [][2]string
+
+  for key, values := range u.Query() {
+    for _, v := range values {
+      pairs = append(pairs, [2]string{ uriEncode(key, false), uriEncode(v, false) })
+    }
+  }
+
+  sort.Slice(pairs, func(i, j int) bool {
+    if(pairs[i][0] != pairs[j][0]){
+      return pairs[i][0] < pairs[j][0]
+    }
+    return pairs[i][1] < pairs[j][1]
+  })
+
+  query := make([]string, len(pairs))
+
+  for i, p := range pairs {
+    query[i] = p[0] + "=" + p[1]
+  }
+
+  return strings.Join(query, "&")
+}
+
+//
+// func canonicalHeaderValue(req *http.Request, name string) string
+//
+// Header values trimmed, runs of spaces squashed, multiple values
+// comma joined.  Host comes from the request itself
+//
+
+func canonicalHeaderValue(req *http.Request, name string) string {
+
+  if(strings.EqualFold(name, "host")){
+    if(req.Host != ""){
+      return req.Host
+    }
+    return req.URL.Host
+  }
+
+  values := append([]string(nil), req.Header.Values(name)...)
+
+  for i, v := range values {
+    values[i] = strings.Join(strings.Fields(v), " ")
+  }
+
+  return strings.Join(values, ",")
+}
@@ -0,0 +1,239 @@
+package restapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//
+// Cases from the AWS SigV4 test suite (aws4_testsuite) - credentials
+// AKIDEXAMPLE, us-east-1, service "service", 20150830T123600Z
+//
+
+func TestAWSSignerTestSuite(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		contentType   string
+		body          string
+		signedHeaders string
+		signature     string
+	}{
+		{"get-vanilla", "GET", "/", "", "",
+			"host;x-amz-date", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "GET", "/?Param2=value2&Param1=value1", "", "",
+			"host;x-amz-date", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"get-vanilla-empty-query-key", "GET", "/?Param1=value1", "", "",
+			"host;x-amz-date", "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb"},
+		{"get-vanilla-query-unreserved", "GET",
+			"/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			"", "", "host;x-amz-date", "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197"},
+		{"post-vanilla", "POST", "/", "", "",
+			"host;x-amz-date", "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"post-vanilla-query", "POST", "/?Param1=value1", "", "",
+			"host;x-amz-date", "28038455d6de14eafc1f9222cf5aa6f1a96197d7deb8263271d420d138af7f11"},
+		{"post-x-www-form-urlencoded", "POST", "/", "application/x-www-form-urlencoded", "Param1=value1",
+			"content-type;host;x-amz-date", "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+	}
+
+	s := NewAWSSigner("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service")
+	s.Now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req, _ := http.NewRequest(tt.method, "https://example.amazonaws.com"+tt.url, strings.NewReader(tt.body))
+
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			if err := s.Sign(req, []byte(tt.body)); err != nil {
+				t.Fatal(err)
+			}
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=" + tt.signedHeaders + ", Signature=" + tt.signature
+
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization =\n  %s\nwant\n  %s", got, want)
+			}
+
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %s", got)
+			}
+		})
+	}
+}
+
+//
+// A file streamed with SetBodyReader() can't be read twice to hash it -
+// with UnsignedPayload it must go out as is, with a Content-Length
+//
+
+func TestAWSSignerUnsignedPayloadStream(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "object.bin")
+	data := strings.Repeat("0123456789", 1000)
+
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []byte
+	var header http.Header
+	var length int64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		length = r.ContentLength
+		got, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	s := NewAWSSigner("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "s3")
+	s.UnsignedPayload = true
+
+	r := New(Put, "upload", srv.URL+"/bucket/object.bin")
+	r.SetSigner(s)
+	r.SetBodyReader(f, "")
+
+	if _, err := r.Do(); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	if string(got) != data {
+		t.Fatalf("server got %d bytes, want %d", len(got), len(data))
+	}
+
+	if length != int64(len(data)) {
+		t.Errorf("Content-Length = %d, want %d", length, len(data))
+	}
+
+	if header.Get("X-Amz-Content-Sha256") != "UNSIGNED-PAYLOAD" {
+		t.Errorf("X-Amz-Content-Sha256 = %s", header.Get("X-Amz-Content-Sha256"))
+	}
+
+	if !strings.HasPrefix(header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		t.Errorf("Authorization = %s", header.Get("Authorization"))
+	}
+}
+
+func TestAWSSignerSignedPayloadNeedsBody(t *testing.T) {
+
+	s := NewAWSSigner("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "s3")
+
+	r := New(Put, "upload", "http://127.0.0.1:1/bucket/object")
+	r.SetSigner(s)
+	r.SetBodyReader(strings.NewReader("data"), "")
+
+	if _, err := r.Do(); err == nil {
+		t.Fatal("signed a body it couldn't hash")
+	}
+}
+
+// GitHub's webhook docs example
+
+func TestHMACSignerWebhook(t *testing.T) {
+
+	s := &HMACSigner{Key: []byte("It's a Secret to Everybody"),
+		SignatureHeader: "X-Hub-Signature-256",
+		SignaturePrefix: "sha256=",
+		Canonicalize:    HMACBody}
+
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hook", nil)
+
+	if err := s.Sign(req, []byte("Hello, World!")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+
+	if got := req.Header.Get("X-Hub-Signature-256"); got != want {
+		t.Fatalf("signature = %s, want %s", got, want)
+	}
+}
+
+func TestHMACSignerCanonicalRequest(t *testing.T) {
+
+	s := NewHMACSigner([]byte("key"))
+	s.KeyID = "k1"
+	s.SignedHeaders = []string{"Content-Type"}
+	s.Now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	body := []byte(`{"a":1}`)
+
+	req, _ := http.NewRequest(http.MethodPost, "https://api.example.com/v1/things?b=2&a=1", nil)
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := s.Sign(req, body); err != nil {
+		t.Fatal(err)
+	}
+
+	bodySum := sha256.Sum256(body)
+
+	canonical := "POST\n/v1/things\na=1&b=2\n1700000000\ncontent-type:application/json\n" +
+		hex.EncodeToString(bodySum[:])
+
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte(canonical))
+
+	if got := req.Header.Get("X-Signature"); got != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("X-Signature = %s", got)
+	}
+
+	if req.Header.Get("X-Timestamp") != "1700000000" || req.Header.Get("X-Key-Id") != "k1" {
+		t.Errorf("headers = %v", req.Header)
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+
+	tests := []struct {
+		url    string
+		double bool
+		want   string
+	}{
+		{"https://b.s3.amazonaws.com/dir%2Ffile.txt", false, "/dir%2Ffile.txt"}, // an escaped / is not a separator
+		{"https://b.s3.amazonaws.com/my%20key%20(1)!.txt", false, "/my%20key%20%281%29%21.txt"},
+		{"https://b.s3.amazonaws.com/a+b/c=d", false, "/a%2Bb/c%3Dd"},
+		{"https://b.s3.amazonaws.com/caf%c3%a9", false, "/caf%C3%A9"},
+		{"https://b.s3.amazonaws.com/", false, "/"},
+		{"https://b.s3.amazonaws.com", false, "/"},
+		{"https://api.example.com/dir%2Ffile", true, "/dir%252Ffile"},
+		{"https://api.example.com/documents and settings/", true, "/documents%2520and%2520settings/"},
+	}
+
+	for _, tt := range tests {
+
+		req, err := http.NewRequest("GET", tt.url, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := canonicalPath(req.URL, tt.double); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+//
+//
+// restapi - Server-Sent Events
+//
+// For apis that push live state over text/event-stream (Home Assistant,
+// EV chargers).  Uses the request's url, headers, auth and TLS settings
+// like Send() does, and reconnects - sending Last-Event-ID so the
+// server can replay anything missed - when the stream drops
+//
+//   r := restapi.NewGet("events", "http://ha.local:8123/api/stream")
+//   r.SetBearerAccessToken(token)
+//
+//   err := r.Subscribe(ctx, func(ev restapi.Event){
+//     fmt.Println(ev.Event, ev.Data)
+//   })
+//
+// Subscribe() runs until ctx is cancelled, the server answers 204 No
+// Content (its way of saying stop) or something that reconnecting
+// won't fix happens (401, 404, not an event stream, a certificate that
+// doesn't verify...)
+//
+//
+
+package restapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seldonsmule/logmsg"
+)
+
+const sseDefaultRetry = 3 * time.Second
+
+type Event struct {
+  ID    string        // last event id seen on the stream
+  Event string        // event type, "message" if the server didn't say
+  Data  string        // data lines joined with "\n"
+  Retry time.Duration // reconnect delay the server asked for, 0 if none
+}
+
+//
+// func (pRA *Restapi) Subscribe(ctx context.Context, fn func(Event)) error
+//
+// Connects and calls fn for each event until ctx is done (returns
+// ctx.Err()), the server sends 204 (returns nil) or a non recoverable
+// error.  Dropped connections, 5xx, 408 and 429 answers are reconnected
+// after the server's retry delay (3 seconds if it never sent one)
+//
+
+func (pRA *Restapi) Subscribe(ctx context.Context, fn func(Event)) error {
+
+  // on the outgoing request only - a later Send() shouldn't ask for
+  // an event stream
+
+  pRA.sAccept = "text/event-stream"
+
+  p := &sseParser{ dRetry: sseDefaultRetry }
+
+  defer func() {
+    pRA.sAccept = ""
+    pRA.sLastEventID = ""
+  }()
+
+  for {
+
+    pRA.sLastEventID = p.sLastID
+
+    resp, body, err := pRA.StreamContext(ctx)
+
+    if(err == nil){
+
+      if(resp.StatusCode == http.StatusNoContent){
+        body.Close()
+        return nil
+      }
+
+      if(!isEventStream(resp.ContentType)){
+        body.Close()
+        return &DecodeError{ Name: pRA.sName, Format: "event-stream",
+                             Err: fmt.Errorf("Content-Type is [%s]", resp.ContentType) }
+      }
+
+      err = p.parse(body, fn)
+
+      body.Close()
+
+      if(err != nil){
+        err = pRA.transportError(err)
+      }
+    }
+
+    if(ctx.Err() != nil){
+      return ctx.Err()
+    }
+
+    if(!reconnectable(err)){
+      return err
+    }
+
+    msg := fmt.Sprintf("Subscribe(%s): stream closed, reconnecting in %s",
+                       pRA.sName, p.dRetry)
+
+    if(err != nil){
+      msg += " - " + err.Error()
+    }
+
+    logmsg.Print(logmsg.Warning, msg)
+
+    if(!sleepContext(ctx, p.dRetry)){
+      return ctx.Err()
+    }
+  }
+}
+
+//
+// func (pRA *Restapi) SubscribeChan(ctx context.Context) (<-chan Event, <-chan error)
+//
+// Subscribe() delivering on a channel.  The event channel is closed
+// when the subscription ends and the reason (nil after a 204) is then
+// sent on the error channel
+//
+
+func (pRA *Restapi) SubscribeChan(ctx context.Context) (<-chan Event, <-chan error) {
+
+  events := make(chan Event)
+  errc := make(chan error, 1)
+
+  go func() {
+
+    err := pRA.Subscribe(ctx, func(ev Event){
+      select {
+        case events <- ev:
+        case <-ctx.Done():
+      }
+    })
+
+    close(events)
+
+    errc <- err
+    close(errc)
+  }()
+
+  return events, errc
+}
+
+//
+// func reconnectable(err error) bool
+//
+// A clean end of stream, a dropped connection or a server having a bad
+// moment are worth reconnecting after.  A bad certificate, pin mismatch
+// or unknown host (see isTransientError) are not
+//
+
+func reconnectable(err error) bool {
+
+  if(err == nil){
+    return true
+  }
+
+  var statusErr *StatusError
+
+  if(errors.As(err, &statusErr)){
+    code := statusErr.StatusCode
+    return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+  }
+
+  return errors.Is(err, ErrTransport) && isTransientError(err)
+}
+
+func isEventStream(contentType string) bool {
+
+  mediaType, _, err := mime.ParseMediaType(contentType)
+
+  return err == nil && mediaType == "text/event-stream"
+}
+
+//
+// Event stream parser state - kept across reconnects for the last
+// event id and retry delay
+//
+
+type sseParser struct {
+  sLastID string
+  dRetry  time.Duration
+
+  sEvent string
+  aData  []string
+  dSent  time.Duration // retry to report on the next event
+}
+
+//
+// func (p *sseParser) parse(body io.Reader, fn func(Event)) error
+//
+// Reads events until the body ends.  A clean end returns nil, else the
+// read error
+//
+
+func (p *sseParser) parse(body io.Reader, fn func(Event)) error {
+
+  scanner := bufio.NewScanner(body)
+  scanner.Buffer(make([]byte, 0, 64 * 1024), 1024 * 1024)
+  scanner.Split(scanSSELines)
+
+  p.sEvent = ""
+  p.aData = nil
+
+  for scanner.Scan() {
+
+    line := scanner.Text()
+
+    if(line == ""){
+      p.dispatch(fn)
+      continue
+    }
+
+    if(strings.HasPrefix(line, ":")){
+      continue // comment / keep-alive
+    }
+
+    field, value, _ := strings.Cut(line, ":")
+    value = strings.TrimPrefix(value, " ")
+
+    switch field {
+      case "event":
+        p.sEvent = value
+      case "data":
+        p.aData = append(p.aData, value)
+      case "id":
+        if(!strings.ContainsRune(value, 0)){
+          p.sLastID = value
+        }
+      case "retry":
+        if ms, err := strconv.ParseInt(value, 10, 64); err == nil && ms >= 0 {
+          p.dRetry = time.Duration(ms) * time.Millisecond
+          p.dSent = p.dRetry
+        }
+    }
+  }
+
+  // an event without its closing blank line is dropped, as the spec says
+
+  return scanner.Err()
+}
+
+//
+// func (p *sseParser) dispatch(fn func(Event))
+//
+// A blank line ends the event
+//
+
+func (p *sseParser) dispatch(fn func(Event)){
+
+  if(p.aData == nil){
+    p.sEvent = ""
+    return
+  }
+
+  ev := Event{ ID: p.sLastID, Event: p.sEvent, Data: strings.Join(p.aData, "\n"), Retry: p.dSent }
+
+  if(ev.Event == ""){
+    ev.Event = "message"
+  }
+
+  p.sEvent = ""
+  p.aData = nil
+  p.dSent = 0
+
+  fn(ev)
+}
+
+//
+// func scanSSELines(data []byte, atEOF bool) (int, []byte, error)
+//
+// bufio.SplitFunc for event stream lines - ended by \r\n, \n or \r
+//
+
+func scanSSELines(data []byte, atEOF bool) (int, []byte, error) {
+
+  if(atEOF && len(data) == 0){
+    return 0, nil, nil
+  }
+
+  if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+
+    if(data[i] == '\n'){
+      return i + 1, data[:i], nil
+    }
+
+    // \r - may be the start of \r\n
+
+    if(i + 1 < len(data)){
+      if(data[i+1] == '\n'){
+        return i + 2, data[:i], nil
+      }
+      return i + 1, data[:i], nil
+    }
+
+    if(atEOF){
+      return i + 1, data[:i], nil
+    }
+
+    return 0, nil, nil // need more to tell
+  }
+
+  if(atEOF){
+    return len(data), data, nil
+  }
+
+  return 0, nil, nil
+}
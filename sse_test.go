@@ -0,0 +1,213 @@
+package restapi
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func parseEvents(t *testing.T, stream string) ([]Event, *sseParser) {
+
+	t.Helper()
+
+	p := &sseParser{dRetry: sseDefaultRetry}
+
+	var events []Event
+
+	if err := p.parse(strings.NewReader(stream), func(ev Event) { events = append(events, ev) }); err != nil {
+		t.Fatal(err)
+	}
+
+	return events, p
+}
+
+func TestSSELineEndings(t *testing.T) {
+
+	want := []Event{
+		{ID: "1", Event: "update", Data: "a\nb"},
+		{ID: "1", Event: "message", Data: "c"},
+	}
+
+	for name, eol := range map[string]string{"LF": "\n", "CRLF": "\r\n", "CR": "\r"} {
+
+		t.Run(name, func(t *testing.T) {
+
+			stream := strings.Join([]string{
+				": comment", "id: 1", "event: update", "data: a", "data:b", "",
+				"data: c", "", "data: never finished",
+			}, eol)
+
+			events, p := parseEvents(t, stream)
+
+			if !reflect.DeepEqual(events, want) {
+				t.Errorf("events = %+v, want %+v", events, want)
+			}
+
+			if p.sLastID != "1" {
+				t.Errorf("last id = %q", p.sLastID)
+			}
+		})
+	}
+}
+
+func TestSSERetry(t *testing.T) {
+
+	events, p := parseEvents(t, "retry: 2500\ndata: x\n\ndata: y\n\nretry: soon\n\n")
+
+	if p.dRetry != 2500*time.Millisecond {
+		t.Errorf("retry = %s, want 2.5s", p.dRetry)
+	}
+
+	// reported once, on the event after it was sent
+
+	if len(events) != 2 || events[0].Retry != 2500*time.Millisecond || events[1].Retry != 0 {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestSSEEmptyIDAndNoData(t *testing.T) {
+
+	events, p := parseEvents(t, "id: 7\nevent: ping\n\nid\ndata: z\n\n")
+
+	if len(events) != 1 || events[0].Data != "z" || events[0].Event != "message" {
+		t.Errorf("events = %+v", events)
+	}
+
+	if p.sLastID != "" {
+		t.Errorf("an empty id field should reset the last id, got %q", p.sLastID)
+	}
+}
+
+func TestSubscribeReconnect(t *testing.T) {
+
+	var mu sync.Mutex
+	var seen []string // Last-Event-ID|Accept per connection
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mu.Lock()
+		seen = append(seen, r.Header.Get("Last-Event-ID")+"|"+r.Header.Get("Accept"))
+		n := len(seen)
+		mu.Unlock()
+
+		switch n {
+		case 1:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("retry: 10\nid: 41\ndata: one\n\nid: 42\ndata: two\n\n"))
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable) // worth another go
+		case 3:
+			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+			w.Write([]byte("id: 43\ndata: three\n\n"))
+		default:
+			w.WriteHeader(http.StatusNoContent) // done
+		}
+	}))
+	defer srv.Close()
+
+	r := NewGet("events", srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var data []string
+
+	err := r.Subscribe(ctx, func(ev Event) { data = append(data, ev.ID+":"+ev.Data) })
+
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	if strings.Join(data, ",") != "41:one,42:two,43:three" {
+		t.Errorf("events = %v", data)
+	}
+
+	want := []string{"|text/event-stream", "42|text/event-stream", "42|text/event-stream", "43|text/event-stream"}
+
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("requests = %v, want %v", seen, want)
+	}
+
+	// nothing left behind on the Restapi for a later Send()
+
+	if r.GetHeader("Accept") != "" || r.sAccept != "" || r.sLastEventID != "" {
+		t.Errorf("Accept %q, sAccept %q, last id %q left set", r.GetHeader("Accept"), r.sAccept, r.sLastEventID)
+	}
+}
+
+func TestSubscribeKeepsCallerAccept(t *testing.T) {
+
+	var accept string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	r := NewGet("events", srv.URL)
+	r.SetHeader("Accept", "text/event-stream, application/json")
+
+	if err := r.Subscribe(context.Background(), func(Event) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if accept != "text/event-stream, application/json" {
+		t.Errorf("Accept = %q", accept)
+	}
+}
+
+func TestSubscribeNotAnEventStream(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	err := NewGet("events", srv.URL).Subscribe(context.Background(), func(Event) {})
+
+	if _, ok := err.(*DecodeError); !ok {
+		t.Fatalf("err = %v, want a DecodeError", err)
+	}
+}
+
+func TestSubscribeUntrustedCertificate(t *testing.T) {
+
+	var hits atomic.Int32
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// reconnecting won't fix a certificate - the error comes back rather
+	// than Subscribe() redialling until ctx ends
+
+	err := NewGet("events", srv.URL).Subscribe(ctx, func(Event) {})
+
+	var unknownAuth x509.UnknownAuthorityError
+
+	if ctx.Err() != nil || !errors.As(err, &unknownAuth) {
+		t.Fatalf("err = %v, want the certificate error", err)
+	}
+
+	if hits.Load() != 0 {
+		t.Errorf("handler reached %d times", hits.Load())
+	}
+}
@@ -0,0 +1,414 @@
+//
+//
+// restapi - streamed responses and downloads
+//
+// Send() reads the whole response into BodyBytes/BodyString, which is
+// no good for firmware images, video clips or multi-GB backups.  These
+// hand the body over as it arrives instead
+//
+//   _, body, err := r.Stream()        // read it yourself, then Close()
+//   n, err := r.StreamTo(w)           // copy into any io.Writer
+//   err := r.DownloadFile("/tmp/x")   // to a file, resuming if cut off
+//
+// The request timeout only covers getting the response headers when
+// streaming - reading the body takes as long as it takes (use a context
+// to put a limit on it).  SetMaxBodySize() guards the buffered Send()
+//
+//
+
+package restapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// func (pRA *Restapi) SetMaxBodySize(max int64)
+//
+// Send()/Do() fail with ErrBodyTooLarge rather than buffer a response
+// bigger than max bytes.  0 for no limit.  Not applied when streaming,
+// other than to each JSONLines() line
+//
+
+func (pRA *Restapi) SetMaxBodySize(max int64){
+  pRA.nMaxBodySize = max
+}
+
+//
+// func (c *Client) SetMaxBodySize(max int64)
+//
+// The SetMaxBodySize() given to requests created after the call
+//
+
+func (c *Client) SetMaxBodySize(max int64){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.nMaxBodySize = max
+}
+
+//
+// func (pRA *Restapi) OnProgress(fn ProgressFunc)
+//
+// fn is called as a streamed body or download is read.  total is -1
+// if the server didn't send a Content-Length
+//
+
+func (pRA *Restapi) OnProgress(fn ProgressFunc){
+  pRA.fProgress = fn
+}
+
+//
+// func (pRA *Restapi) Stream() (*Response, io.ReadCloser, error)
+//
+// Sends the request and returns the body unread.  The caller must
+// Close() it.  Response.Body, BodyBytes and RawData are not filled in
+//
+
+func (pRA *Restapi) Stream() (*Response, io.ReadCloser, error) {
+  return pRA.StreamContext(context.Background())
+}
+
+//
+// func (pRA *Restapi) StreamContext(ctx context.Context) (*Response, io.ReadCloser, error)
+//
+// Stream() with a context - cancelling it also stops the body read
+//
+
+func (pRA *Restapi) StreamContext(ctx context.Context) (*Response, io.ReadCloser, error) {
+
+  var body io.ReadCloser
+
+  resp, err := pRA.doLoop(ctx, func(ctx context.Context) (*Response, error) {
+
+    res, resp, err := pRA.streamOnce(ctx)
+
+    if(err == nil){
+      body = res.Body
+    }
+
+    return resp, err
+  })
+
+  if(err != nil){
+    return resp, nil, err
+  }
+
+  if(pRA.fProgress != nil){
+    body = &progressReader{ rc: body, total: resp.ContentLength, fn: pRA.fProgress }
+  }
+
+  return resp, body, nil
+}
+
+//
+// func (pRA *Restapi) StreamTo(w io.Writer) (int64, error)
+//
+// Sends the request and copies the body into w.  Returns the number of
+// bytes written
+//
+
+func (pRA *Restapi) StreamTo(w io.Writer) (int64, error) {
+  return pRA.StreamToContext(context.Background(), w)
+}
+
+//
+// func (pRA *Restapi) StreamToContext(ctx context.Context, w io.Writer) (int64, error)
+//
+// StreamTo() with a context
+//
+
+func (pRA *Restapi) StreamToContext(ctx context.Context, w io.Writer) (int64, error) {
+
+  _, body, err := pRA.StreamContext(ctx)
+
+  if(err != nil){
+    return 0, err
+  }
+
+  defer body.Close()
+
+  n, err := io.Copy(w, body)
+
+  if(err != nil){
+    return n, pRA.transportError(err)
+  }
+
+  return n, nil
+}
+
+//
+// func (pRA *Restapi) DownloadFile(path string) error
+//
+// Downloads into path.  The data goes to path.part first and is renamed
+// once complete.  If a path.part is left from an earlier attempt the
+// download carries on from where it stopped using a Range request.  The
+// ETag (or Last-Modified) of the first response is kept in
+// path.part.ifrange and sent as If-Range, so if the file changed on the
+// server - or the server doesn't do ranges - it starts over
+//
+
+func (pRA *Restapi) DownloadFile(path string) error {
+  return pRA.DownloadFileContext(context.Background(), path)
+}
+
+//
+// func (pRA *Restapi) DownloadFileContext(ctx context.Context, path string) error
+//
+// DownloadFile() with a context
+//
+
+func (pRA *Restapi) DownloadFileContext(ctx context.Context, path string) error {
+
+  part := path + ".part"
+  meta := part + ".ifrange"
+
+  f, err := os.OpenFile(part, os.O_RDWR | os.O_CREATE, 0644)
+
+  if(err != nil){
+    return err
+  }
+
+  defer f.Close()
+
+  info, err := f.Stat()
+
+  if(err != nil){
+    return err
+  }
+
+  offset := info.Size()
+
+  validator, err := os.ReadFile(meta)
+
+  if(err != nil || len(validator) == 0){
+    // nothing to tell a changed file from the one we started - the
+    // partial data can't be trusted
+    offset = 0
+  }
+
+  err = pRA.download(ctx, f, meta, offset, string(validator))
+
+  var statusErr *StatusError
+
+  if(errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusRequestedRangeNotSatisfiable){
+    // the .part doesn't fit what's on the server now - start over
+    err = pRA.download(ctx, f, meta, 0, "")
+  }
+
+  if(err != nil){
+    return err
+  }
+
+  if err := f.Close(); err != nil {
+    return err
+  }
+
+  if err := os.Rename(part, path); err != nil {
+    return err
+  }
+
+  os.Remove(meta)
+
+  return nil
+}
+
+//
+// func (pRA *Restapi) download(ctx context.Context, f *os.File, meta string, offset int64, validator string) error
+//
+// Fetches from offset into f.  The server answering 200 to a range
+// request means it is sending the whole thing (the file changed, or it
+// ignores ranges), so f is truncated and the new validator saved to meta
+//
+
+func (pRA *Restapi) download(ctx context.Context, f *os.File, meta string, offset int64, validator string) error {
+
+  pRA.sRange = ""
+  pRA.sIfRange = ""
+
+  if(offset > 0){
+    pRA.sRange = fmt.Sprintf("bytes=%d-", offset)
+    pRA.sIfRange = validator
+  }
+
+  defer func() {
+    pRA.sRange = ""
+    pRA.sIfRange = ""
+  }()
+
+  resp, body, err := pRA.StreamContext(ctx)
+
+  if(err != nil){
+    return err
+  }
+
+  defer body.Close()
+
+  if(resp.StatusCode == http.StatusPartialContent){
+    start, ok := contentRangeStart(resp.Header.Get("Content-Range"))
+
+    if(!ok || start != offset){
+      return pRA.transportError(fmt.Errorf("server sent range [%s], asked for %s",
+                                           resp.Header.Get("Content-Range"), pRA.sRange))
+    }
+  }else{
+    offset = 0
+
+    if err := saveValidator(meta, resp.Header); err != nil {
+      return err
+    }
+  }
+
+  if err := f.Truncate(offset); err != nil {
+    return err
+  }
+
+  if _, err := f.Seek(offset, io.SeekStart); err != nil {
+    return err
+  }
+
+  // the progress wrapper from StreamContext() counts from 0 - redo it
+  // so the numbers cover the whole file
+
+  if p, ok := body.(*progressReader); ok {
+    p.done = offset
+    if(p.total >= 0){
+      p.total += offset
+    }
+  }
+
+  if _, err := io.Copy(f, body); err != nil {
+    return pRA.transportError(err)
+  }
+
+  return nil
+}
+
+//
+// func saveValidator(meta string, header http.Header) error
+//
+// Keeps what to send as If-Range when resuming - a strong ETag, else
+// Last-Modified.  Without either any old one is removed so a resume
+// starts over rather than risk mixing two versions of the file
+//
+
+func saveValidator(meta string, header http.Header) error {
+
+  validator := header.Get("ETag")
+
+  if(validator == "" || strings.HasPrefix(validator, "W/")){
+    validator = header.Get("Last-Modified") // weak ETags aren't allowed in If-Range
+  }
+
+  if(validator == ""){
+    err := os.Remove(meta)
+
+    if(err != nil && !os.IsNotExist(err)){
+      return err
+    }
+
+    return nil
+  }
+
+  return os.WriteFile(meta, []byte(validator), 0644)
+}
+
+//
+// func (pRA *Restapi) streamOnce(ctx context.Context) (*http.Response, *Response, error)
+//
+// One attempt for Stream() - the body is left open on success.  The
+// request timeout only runs until the headers arrive
+//
+
+func (pRA *Restapi) streamOnce(ctx context.Context) (*http.Response, *Response, error) {
+
+  ctx, cancel := context.WithCancel(ctx)
+
+  var timer *time.Timer
+
+  if(pRA.dTimeout > 0){
+    timer = time.AfterFunc(pRA.dTimeout, cancel)
+  }
+
+  res, resp, err := pRA.roundTrip(ctx, true)
+
+  if(timer != nil){
+    timer.Stop()
+  }
+
+  if(err != nil){
+    cancel()
+    return nil, resp, err
+  }
+
+  if(!pRA.IsAcceptedStatus(res.StatusCode)){
+
+    // small error bodies are still worth keeping
+
+    body, _ := io.ReadAll(io.LimitReader(res.Body, 64 * 1024))
+
+    res.Body.Close()
+    cancel()
+
+    resp.Body = body
+    pRA.BodyBytes = body
+    pRA.BodyString = string(body)
+
+    return nil, resp, pRA.statusError(resp)
+  }
+
+  res.Body = &cancelReadCloser{ ReadCloser: res.Body, cancel: cancel }
+
+  return res, resp, nil
+}
+
+//
+// Releases the stream's context when the body is closed
+//
+
+type cancelReadCloser struct {
+  io.ReadCloser
+  cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+
+  err := c.ReadCloser.Close()
+  c.cancel()
+
+  return err
+}
+
+//
+// func contentRangeStart(value string) (int64, bool)
+//
+// The first byte from "bytes 100-999/1000"
+//
+
+func contentRangeStart(value string) (int64, bool) {
+
+  rest, found := strings.CutPrefix(value, "bytes ")
+
+  if(!found){
+    return 0, false
+  }
+
+  first, _, found := strings.Cut(rest, "-")
+
+  if(!found){
+    return 0, false
+  }
+
+  start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+
+  return start, err == nil
+}
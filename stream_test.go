@@ -0,0 +1,150 @@
+package restapi
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// fileServer serves content with an ETag through http.ServeContent,
+// which does Range and If-Range, and records the request headers
+//
+
+type fileServer struct {
+	mu      sync.Mutex
+	content string
+	etag    string
+	ranges  []string
+}
+
+func (fs *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	fs.mu.Lock()
+	content, etag := fs.content, fs.etag
+	fs.ranges = append(fs.ranges, r.Header.Get("Range")+"|"+r.Header.Get("If-Range"))
+	fs.mu.Unlock()
+
+	w.Header().Set("ETag", etag)
+
+	http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(content))
+}
+
+func TestDownloadFileResume(t *testing.T) {
+
+	v1 := strings.Repeat("version one ", 1000)
+	v2 := strings.Repeat("VERSION TWO ", 1000)
+
+	fs := &fileServer{content: v1, etag: `"v1"`}
+
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.bin")
+
+	check := func(want string, wantRanges string) {
+
+		t.Helper()
+
+		got, err := os.ReadFile(path)
+
+		if err != nil || !bytes.Equal(got, []byte(want)) {
+			t.Fatalf("file is %d bytes (err %v), want %d", len(got), err, len(want))
+		}
+
+		if _, err := os.Stat(path + ".part.ifrange"); !os.IsNotExist(err) {
+			t.Errorf(".part.ifrange left behind")
+		}
+
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+
+		if strings.Join(fs.ranges, ",") != wantRanges {
+			t.Errorf("requests %v, want %s", fs.ranges, wantRanges)
+		}
+
+		fs.ranges = nil
+		os.Remove(path)
+	}
+
+	r := NewGet("download", srv.URL)
+
+	// fresh download
+
+	if err := r.DownloadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	check(v1, "|")
+
+	// a .part left from an interrupted download of the same version
+
+	os.WriteFile(path+".part", []byte(v1[:5000]), 0644)
+	os.WriteFile(path+".part.ifrange", []byte(`"v1"`), 0644)
+
+	if err := r.DownloadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	check(v1, `bytes=5000-|"v1"`)
+
+	// the file changed on the server since - If-Range gets us all of v2
+	// rather than v2's tail on v1's head
+
+	fs.mu.Lock()
+	fs.content, fs.etag = v2, `"v2"`
+	fs.mu.Unlock()
+
+	os.WriteFile(path+".part", []byte(v1[:5000]), 0644)
+	os.WriteFile(path+".part.ifrange", []byte(`"v1"`), 0644)
+
+	if err := r.DownloadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	check(v2, `bytes=5000-|"v1"`)
+
+	// a .part with nothing to check it against isn't resumed
+
+	os.WriteFile(path+".part", []byte(v1[:5000]), 0644)
+
+	if err := r.DownloadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	check(v2, "|")
+}
+
+func TestSaveValidator(t *testing.T) {
+
+	meta := filepath.Join(t.TempDir(), "x.part.ifrange")
+
+	tests := []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{"Etag": {`"abc"`}, "Last-Modified": {"Wed, 21 Oct 2015 07:28:00 GMT"}}, `"abc"`},
+		{http.Header{"Etag": {`W/"abc"`}, "Last-Modified": {"Wed, 21 Oct 2015 07:28:00 GMT"}}, "Wed, 21 Oct 2015 07:28:00 GMT"},
+		{http.Header{}, ""},
+	}
+
+	for _, tt := range tests {
+
+		if err := saveValidator(meta, tt.header); err != nil {
+			t.Fatal(err)
+		}
+
+		got, _ := os.ReadFile(meta)
+
+		if string(got) != tt.want {
+			t.Errorf("saved %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+//
+//
+// restapi - tls
+//
+// TLS settings scoped to a Client (shared by all its requests) or to a
+// single Restapi.  Replaces TurnOffCertValidation() which used to flip
+// http.DefaultTransport and so turned off verification for every other
+// library in the binary too
+//
+//
+
+package restapi
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type TLSOptions struct {
+
+  InsecureSkipVerify bool           // don't verify the server certificate
+  RootCAs            *x509.CertPool // CAs to trust, nil = system pool
+  MinVersion         uint16         // tls.VersionTLS12, etc (0 = go default)
+  ServerName         string         // override the name checked / sent as SNI
+  CipherSuites       []uint16       // TLS 1.2 and below only
+
+  PinnedCerts        []*x509.Certificate // see PinCertificate()
+  PinnedSPKIHashes   [][]byte            // see PinSPKIHash()
+
+  ClientCert         *ClientCert    // presented for mutual TLS
+
+}
+
+//
+// func (o *TLSOptions) Clone() *TLSOptions
+//
+// Copy of the options, safe to change without touching the original
+//
+
+func (o *TLSOptions) Clone() *TLSOptions {
+
+  if(o == nil){
+    return &TLSOptions{}
+  }
+
+  c := *o
+  c.CipherSuites = append([]uint16(nil), o.CipherSuites...)
+  c.PinnedCerts = append([]*x509.Certificate(nil), o.PinnedCerts...)
+  c.PinnedSPKIHashes = append([][]byte(nil), o.PinnedSPKIHashes...)
+
+  return &c
+}
+
+//
+// func (o *TLSOptions) cacheKey() string
+//
+// Equal for options that give the same tls.Config, so requests can
+// share a transport.  Pools and client certs not loaded from files
+// count as equal only when they are the same object
+//
+
+func (o *TLSOptions) cacheKey() string {
+
+  var b strings.Builder
+
+  fmt.Fprintf(&b, "%t %p %d %q %v", o.InsecureSkipVerify, o.RootCAs, o.MinVersion,
+              o.ServerName, o.CipherSuites)
+
+  b.WriteString(" pins")
+
+  for _, cert := range o.PinnedCerts {
+    sum := sha256.Sum256(cert.Raw)
+    b.WriteString(" " + hex.EncodeToString(sum[:]))
+  }
+
+  b.WriteString(" spki")
+
+  for _, hash := range o.PinnedSPKIHashes {
+    b.WriteString(" " + hex.EncodeToString(hash))
+  }
+
+  b.WriteString(" client " + o.ClientCert.cacheKey())
+
+  return b.String()
+}
+
+//
+// func (o *TLSOptions) tlsConfig() *tls.Config
+//
+// Turns the options into a tls.Config, nil if there is nothing to set
+//
+
+func (o *TLSOptions) tlsConfig() *tls.Config {
+
+  if(o == nil){
+    return nil
+  }
+
+  cfg := &tls.Config{ InsecureSkipVerify: o.InsecureSkipVerify,
+                      RootCAs: o.RootCAs,
+                      MinVersion: o.MinVersion,
+                      ServerName: o.ServerName }
+
+  if(len(o.CipherSuites) > 0){
+    cfg.CipherSuites = append([]uint16(nil), o.CipherSuites...)
+  }
+
+  if(o.ClientCert != nil){
+    cfg.GetClientCertificate = o.ClientCert.getClientCertificate
+  }
+
+  // pinned - the normal chain and hostname checks are replaced by
+  // comparing against the pins
+
+  if(o.hasPins()){
+    pins := o.Clone()
+
+    cfg.InsecureSkipVerify = true
+    cfg.VerifyPeerCertificate = pins.verifyPins
+  }
+
+  return cfg
+}
+
+//
+// func (c *Client) SetTLSOptions(opts *TLSOptions)
+//
+// TLS settings for every request sent over this client.  A copy is
+// kept so later changes to opts have no effect
+//
+// opts - nil to go back to the defaults
+//
+
+func (c *Client) SetTLSOptions(opts *TLSOptions){
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if(opts == nil){
+    c.pTLSOptions = nil
+  }else{
+    c.pTLSOptions = opts.Clone()
+  }
+
+  c.resetTransports()
+}
+
+//
+// func (c *Client) GetTLSOptions() *TLSOptions
+//
+// Returns a copy of the client's TLS settings
+//
+
+func (c *Client) GetTLSOptions() *TLSOptions{
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  return c.pTLSOptions.Clone()
+}
+
+//
+// func (c *Client) SetInsecureSkipVerify(skip bool)
+//
+// Turns server certificate verification off (true) or on (false) for
+// this client only.  Meant for known self signed devices - better yet
+// use UseCert() or pin the certificate
+//
+
+func (c *Client) SetInsecureSkipVerify(skip bool){
+
+  opts := c.GetTLSOptions()
+  opts.InsecureSkipVerify = skip
+
+  c.SetTLSOptions(opts)
+}
+
+//
+// func (pRA *Restapi) SetTLSOptions(opts *TLSOptions)
+//
+// TLS settings for this request only, replacing the client's.  The
+// transport built for them is kept on the Client and shared with any
+// other request that has the same settings
+//
+// opts - nil to go back to using the client's settings
+//
+
+func (pRA *Restapi) SetTLSOptions(opts *TLSOptions){
+
+  if(opts == nil){
+    pRA.pTLSOptions = nil
+  }else{
+    pRA.pTLSOptions = opts.Clone()
+  }
+}
+
+//
+// func (pRA *Restapi) GetTLSOptions() *TLSOptions
+//
+// Returns a copy of the TLS settings used by this request - its own if
+// set, otherwise the client's
+//
+
+func (pRA *Restapi) GetTLSOptions() *TLSOptions{
+
+  if(pRA.pTLSOptions != nil){
+    return pRA.pTLSOptions.Clone()
+  }
+
+  return pRA.pClient.GetTLSOptions()
+}
+
+//
+// func (pRA *Restapi) SetInsecureSkipVerify(skip bool)
+//
+// Turns server certificate verification off (true) or on (false) for
+// this request only
+//
+
+func (pRA *Restapi) SetInsecureSkipVerify(skip bool){
+
+  opts := pRA.GetTLSOptions()
+  opts.InsecureSkipVerify = skip
+
+  pRA.SetTLSOptions(opts)
+}
+
+//
+// func loadCertPool(certfile string) (*x509.CertPool, error)
+//
+// Reads a PEM file of CA certificates to trust
+//
+
+func loadCertPool(certfile string) (*x509.CertPool, error) {
+
+  caCert, err := os.ReadFile(certfile)
+
+  if(err != nil){
+    return nil, &CertError{ File: certfile, Err: err }
+  }
+
+  pool := x509.NewCertPool()
+
+  if(!pool.AppendCertsFromPEM(caCert)){
+    return nil, &CertError{ File: certfile, Err: fmt.Errorf("no PEM certificates found") }
+  }
+
+  return pool, nil
+}
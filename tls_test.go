@@ -0,0 +1,82 @@
+package restapi
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRequestTLSOptionsShareTransport(t *testing.T) {
+
+	var conns atomic.Int32
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	c := NewClient()
+
+	// a new request per poll, each with its own (equal) settings
+
+	for i := 0; i < 3; i++ {
+
+		r := c.NewGet("poll", srv.URL)
+		r.PinCertificate(srv.Certificate())
+
+		if !r.Send() {
+			t.Fatalf("send %d failed, status %d", i, r.GetLastStatusCode())
+		}
+	}
+
+	if n := conns.Load(); n != 1 {
+		t.Errorf("%d connections, want 1 reused", n)
+	}
+
+	if n := len(c.mTLSTransports); n != 1 {
+		t.Errorf("%d transports, want 1", n)
+	}
+
+	// different settings get a transport of their own
+
+	r := c.NewGet("insecure", srv.URL)
+	r.SetInsecureSkipVerify(true)
+
+	if !r.Send() {
+		t.Fatalf("insecure send failed, status %d", r.GetLastStatusCode())
+	}
+
+	if n := len(c.mTLSTransports); n != 2 {
+		t.Errorf("%d transports, want 2", n)
+	}
+}
+
+func TestRequestTLSTransportsCapped(t *testing.T) {
+
+	c := NewClient()
+
+	for i := 0; i < maxTLSTransports+10; i++ {
+
+		opts := &TLSOptions{ServerName: string(rune('a' + i))}
+
+		if _, err := c.tlsTransport(opts, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := len(c.mTLSTransports); n != maxTLSTransports {
+		t.Errorf("%d transports, want %d", n, maxTLSTransports)
+	}
+}
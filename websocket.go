@@ -0,0 +1,1062 @@
+//
+//
+// restapi - WebSocket client
+//
+// For Tesla's streaming api and home automation hubs that switch to a
+// websocket after a REST login.  The handshake is sent like any other
+// request so the url, headers, authenticators, token sources, UseCert()
+// trust and certificate pins all carry over - no second client to keep
+// in step
+//
+//   r := restapi.NewGet("stream", "wss://streaming.vn.teslamotors.com/streaming/")
+//   r.SetBearerAccessToken(token)
+//
+//   opts := restapi.DefaultWebSocketOptions()
+//   opts.OnConnect = func(ws *restapi.WebSocket) error {
+//     return ws.WriteJSON(subscribeMsg) // sent again after a reconnect
+//   }
+//
+//   ws, err := r.DialWebSocket(ctx, opts)
+//   defer ws.Close()
+//
+//   for {
+//     var msg StreamMsg
+//     if err := ws.ReadJSON(&msg); err != nil {
+//       break
+//     }
+//   }
+//
+// Only RFC 6455 basics - no compression extension.  One goroutine may
+// read while others write.  Close() (or cancelling ctx) always gets the
+// connection shut, even if the server has stopped reading and a write
+// is stuck
+//
+//
+
+package restapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/seldonsmule/logmsg"
+)
+
+const (
+  TextMessage   = 1
+  BinaryMessage = 2
+)
+
+const (
+  wsContinuation = 0x0
+  wsClose        = 0x8
+  wsPing         = 0x9
+  wsPong         = 0xA
+
+  wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+  wsCloseNormal    = 1000
+  wsCloseGoingAway = 1001
+  wsCloseProtocol  = 1002
+  wsCloseNoStatus  = 1005
+  wsCloseTooBig    = 1009
+
+  wsMaxMessageSize = 256 << 20       // when MaxMessageSize is 0
+  wsReadChunk      = 64 * 1024       // bigger frames are read as they arrive
+  wsCloseWait      = 1 * time.Second // for the close frame to get out
+)
+
+var ErrWebSocketClosed = errors.New("restapi: websocket closed")
+
+var errWSTooLarge = errors.New("websocket: message too large")
+
+//
+// CloseError
+//
+// The server closed the websocket
+//
+
+type CloseError struct {
+  Code int
+  Text string
+}
+
+func (e *CloseError) Error() string {
+  return fmt.Sprintf("restapi: websocket closed by server: %d %s", e.Code, e.Text)
+}
+
+func (e *CloseError) Is(target error) bool {
+  return target == ErrWebSocketClosed
+}
+
+type WebSocketOptions struct {
+
+  Subprotocols []string // offered in Sec-WebSocket-Protocol
+
+  PingInterval time.Duration // 0 = no keepalive pings
+  PongTimeout  time.Duration // drop the connection if nothing comes back
+
+  MaxMessageSize int64 // 0 = 256MB
+
+  Reconnect         bool          // redial when the connection drops
+  ReconnectDelay    time.Duration // first wait, doubling each failure
+  MaxReconnectDelay time.Duration
+
+  OnConnect func(ws *WebSocket) error // after every (re)connect
+
+}
+
+//
+// func DefaultWebSocketOptions() *WebSocketOptions
+//
+// Ping every 30 seconds and give up 10 seconds later, 16MB messages,
+// reconnect starting at 1 second backing off to 30
+//
+
+func DefaultWebSocketOptions() *WebSocketOptions {
+
+  return &WebSocketOptions{ PingInterval: 30 * time.Second,
+                            PongTimeout: 10 * time.Second,
+                            MaxMessageSize: 16 << 20,
+                            Reconnect: true,
+                            ReconnectDelay: time.Second,
+                            MaxReconnectDelay: 30 * time.Second }
+}
+
+type WebSocket struct {
+
+  pRA        *Restapi
+  pOpts      WebSocketOptions
+  ctx        context.Context // from DialWebSocket() - ends reconnects
+  pTransport *http.Transport // HTTP/1.1 only, for every (re)dial
+
+  wmu sync.Mutex // one frame written at a time - never held with mu
+
+  mu        sync.Mutex // guards the fields below
+  conn      io.ReadWriteCloser
+  br        *bufio.Reader
+  done      chan struct{} // closed when this connection is dropped
+  pResponse *Response
+  bClosed   bool
+
+  nLastSeen atomic.Int64 // unix nanos of the last frame read
+  bPinging  atomic.Bool  // a keepalive ping is on its way out
+
+}
+
+//
+// func (pRA *Restapi) DialWebSocket(ctx context.Context, opts *WebSocketOptions) (*WebSocket, error)
+//
+// Opens a websocket to the request's url (ws://, wss://, http:// or
+// https://).  The request must be a GET.  ctx covers the whole life of
+// the websocket - cancelling it closes the connection and stops any
+// reconnects
+//
+// opts - nil for DefaultWebSocketOptions()
+//
+
+func (pRA *Restapi) DialWebSocket(ctx context.Context, opts *WebSocketOptions) (*WebSocket, error) {
+
+  if(pRA.sMethodString != http.MethodGet){
+    return nil, fmt.Errorf("DialWebSocket(%s): %w [%s] - must be GET", pRA.sName, ErrMethod, pRA.sMethodString)
+  }
+
+  if(opts == nil){
+    opts = DefaultWebSocketOptions()
+  }
+
+  tlsOpts, err := pRA.effectiveTLSOptions()
+
+  if(err != nil){
+    return nil, err
+  }
+
+  ws := &WebSocket{ pRA: pRA, pOpts: *opts, ctx: ctx,
+                    pTransport: pRA.pClient.upgradeTransport(tlsOpts) }
+
+  if err := ws.connect(); err != nil {
+    ws.pTransport.CloseIdleConnections() // a 401/503 answer leaves one idle
+    return nil, err
+  }
+
+  return ws, nil
+}
+
+//
+// func (ws *WebSocket) Response() *Response
+//
+// The server's handshake answer
+//
+
+func (ws *WebSocket) Response() *Response {
+
+  ws.mu.Lock()
+  defer ws.mu.Unlock()
+
+  return ws.pResponse
+}
+
+//
+// func (ws *WebSocket) Subprotocol() string
+//
+// The subprotocol the server picked, "" if none
+//
+
+func (ws *WebSocket) Subprotocol() string {
+
+  if resp := ws.Response(); resp != nil {
+    return resp.Header.Get("Sec-WebSocket-Protocol")
+  }
+
+  return ""
+}
+
+//
+// func (ws *WebSocket) ReadMessage() (int, []byte, error)
+//
+// Next text or binary message - pings are answered along the way.  With
+// Reconnect set a dropped connection is redialled and reading carries
+// on.  Returns a *CloseError if the server closes normally
+//
+
+func (ws *WebSocket) ReadMessage() (int, []byte, error) {
+
+  for {
+
+    ws.mu.Lock()
+    br, closed := ws.br, ws.bClosed
+    ws.mu.Unlock()
+
+    if(closed){
+      return 0, nil, ErrWebSocketClosed
+    }
+
+    msgType, data, err := ws.readMessage(br)
+
+    if(err == nil){
+      return msgType, data, nil
+    }
+
+    if(ws.ctx.Err() != nil){
+      return 0, nil, ws.ctx.Err()
+    }
+
+    if(ws.isClosed()){
+      return 0, nil, ErrWebSocketClosed
+    }
+
+    if(!ws.shouldReconnect(err)){
+      return 0, nil, err
+    }
+
+    msg := fmt.Sprintf("websocket(%s): connection lost, reconnecting - %s", ws.pRA.sName, err)
+    logmsg.Print(logmsg.Warning, msg)
+
+    if err := ws.reconnect(); err != nil {
+      return 0, nil, err
+    }
+  }
+}
+
+//
+// func (ws *WebSocket) WriteMessage(msgType int, data []byte) error
+//
+// Sends a TextMessage or BinaryMessage
+//
+
+func (ws *WebSocket) WriteMessage(msgType int, data []byte) error {
+
+  if(msgType != TextMessage && msgType != BinaryMessage){
+    return fmt.Errorf("websocket: unknown message type %d", msgType)
+  }
+
+  return ws.write(byte(msgType), data)
+}
+
+//
+// func (ws *WebSocket) ReadJSON(v any) error
+//
+// Next message decoded into v (SetStrictDecode()/SetUseNumber() on the
+// request apply)
+//
+
+func (ws *WebSocket) ReadJSON(v any) error {
+
+  _, data, err := ws.ReadMessage()
+
+  if(err != nil){
+    return err
+  }
+
+  return ws.pRA.decodeJSON(data, v, "websocket")
+}
+
+//
+// func (ws *WebSocket) WriteJSON(v any) error
+//
+// Sends v as a json text message
+//
+
+func (ws *WebSocket) WriteJSON(v any) error {
+
+  data, err := json.Marshal(v)
+
+  if(err != nil){
+    return err
+  }
+
+  return ws.write(TextMessage, data)
+}
+
+//
+// func (ws *WebSocket) Ping(data []byte) error
+//
+// Sends a ping (up to 125 bytes)
+//
+
+func (ws *WebSocket) Ping(data []byte) error {
+
+  if(len(data) > 125){
+    return fmt.Errorf("websocket: %d byte ping, 125 is the most", len(data))
+  }
+
+  return ws.write(wsPing, data)
+}
+
+//
+// func (ws *WebSocket) Close() error
+//
+// Tells the server we're going and closes the connection and its
+// transport.  No more reconnects.  Doesn't wait on a server that has
+// stopped reading - a write stuck on it returns an error
+//
+
+func (ws *WebSocket) Close() error {
+
+  ws.mu.Lock()
+
+  if(ws.bClosed){
+    ws.mu.Unlock()
+    return nil
+  }
+
+  ws.bClosed = true
+
+  ws.mu.Unlock()
+
+  defer ws.pTransport.CloseIdleConnections()
+
+  conn := ws.detach(nil)
+
+  if(conn == nil){
+    return nil // already dropped
+  }
+
+  return ws.closeConn(conn, wsCloseNormal)
+}
+
+//
+// func (ws *WebSocket) connect() error
+//
+// Handshake (through the request's retry and 401 handling), then start
+// the keepalive and run OnConnect
+//
+
+func (ws *WebSocket) connect() error {
+
+  var conn io.ReadWriteCloser
+
+  resp, err := ws.pRA.doLoop(ws.ctx, func(ctx context.Context) (*Response, error) {
+
+    c, resp, err := ws.pRA.wsHandshake(ctx, ws.pTransport, ws.pOpts.Subprotocols)
+
+    conn = c
+
+    return resp, err
+  })
+
+  if(err != nil){
+    return err
+  }
+
+  done := make(chan struct{})
+
+  ws.mu.Lock()
+
+  if(ws.bClosed){
+    ws.mu.Unlock()
+    conn.Close()
+    return ErrWebSocketClosed
+  }
+
+  ws.conn = conn
+  ws.br = bufio.NewReader(conn)
+  ws.done = done
+  ws.pResponse = resp
+
+  ws.mu.Unlock()
+
+  ws.nLastSeen.Store(time.Now().UnixNano())
+
+  go func() {
+    select {
+      case <-ws.ctx.Done():
+        ws.drop(done, wsCloseGoingAway)
+      case <-done:
+    }
+  }()
+
+  if(ws.pOpts.PingInterval > 0){
+    go ws.keepalive(done)
+  }
+
+  if(ws.pOpts.OnConnect != nil){
+    if err := ws.pOpts.OnConnect(ws); err != nil {
+      ws.drop(done, wsCloseGoingAway)
+      return err
+    }
+  }
+
+  return nil
+}
+
+//
+// func (ws *WebSocket) reconnect() error
+//
+// Redials with backoff until it works, ctx is done, Close() is called
+// or the server refuses us for good (401, 404, a certificate that no
+// longer verifies, an unknown host...)
+//
+
+func (ws *WebSocket) reconnect() error {
+
+  delay := ws.pOpts.ReconnectDelay
+
+  if(delay <= 0){
+    delay = time.Second
+  }
+
+  for {
+
+    if(!sleepContext(ws.ctx, delay)){
+      return ws.ctx.Err()
+    }
+
+    err := ws.connect()
+
+    if(err == nil){
+      return nil
+    }
+
+    if(errors.Is(err, ErrWebSocketClosed) || !reconnectable(err)){
+      return err
+    }
+
+    msg := fmt.Sprintf("websocket(%s): reconnect failed - %s", ws.pRA.sName, err)
+    logmsg.Print(logmsg.Warning, msg)
+
+    delay *= 2
+
+    if(ws.pOpts.MaxReconnectDelay > 0 && delay > ws.pOpts.MaxReconnectDelay){
+      delay = ws.pOpts.MaxReconnectDelay
+    }
+  }
+}
+
+func (ws *WebSocket) shouldReconnect(err error) bool {
+
+  if(!ws.pOpts.Reconnect || ws.isClosed()){
+    return false
+  }
+
+  var closeErr *CloseError
+
+  if(errors.As(err, &closeErr) && closeErr.Code == wsCloseNormal){
+    return false // the server meant it
+  }
+
+  return true
+}
+
+//
+// func (ws *WebSocket) keepalive(done chan struct{})
+//
+// Pings every PingInterval and drops the connection if nothing at all
+// has been heard for PingInterval + PongTimeout
+//
+
+func (ws *WebSocket) keepalive(done chan struct{}){
+
+  interval := ws.pOpts.PingInterval
+
+  timeout := ws.pOpts.PongTimeout
+
+  if(timeout <= 0){
+    timeout = interval
+  }
+
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+
+  for {
+    select {
+      case <-done:
+        return
+
+      case <-ticker.C:
+        quiet := time.Since(time.Unix(0, ws.nLastSeen.Load()))
+
+        if(quiet > interval + timeout){
+          msg := fmt.Sprintf("websocket(%s): nothing heard for %s, dropping connection",
+                             ws.pRA.sName, quiet.Round(time.Millisecond))
+          logmsg.Print(logmsg.Warning, msg)
+          ws.drop(done, 0)
+          return
+        }
+
+        ws.ping(done, timeout)
+    }
+  }
+}
+
+//
+// func (ws *WebSocket) ping(done chan struct{}, timeout time.Duration)
+//
+// Sends a keepalive ping from its own goroutine so a server that has
+// stopped reading can't stall the keepalive.  A ping that isn't out
+// within timeout (queued behind a stuck write, or stuck itself) drops
+// the connection
+//
+
+func (ws *WebSocket) ping(done chan struct{}, timeout time.Duration){
+
+  if(!ws.bPinging.CompareAndSwap(false, true)){
+    return // the last one is still waiting
+  }
+
+  go func() {
+
+    defer ws.bPinging.Store(false)
+
+    timer := time.AfterFunc(timeout, func() { ws.drop(done, 0) })
+    defer timer.Stop()
+
+    ws.write(wsPing, nil)
+  }()
+}
+
+//
+// func (ws *WebSocket) isClosed() bool
+//
+// Close() has been called
+//
+
+func (ws *WebSocket) isClosed() bool {
+
+  ws.mu.Lock()
+  defer ws.mu.Unlock()
+
+  return ws.bClosed
+}
+
+//
+// func (ws *WebSocket) drop(done chan struct{}, code int)
+//
+// Closes the connection done belongs to (nil for the current one), if
+// it is still open.  code goes in the close frame, 0 to send none
+//
+
+func (ws *WebSocket) drop(done chan struct{}, code int){
+
+  if conn := ws.detach(done); conn != nil {
+    ws.closeConn(conn, code)
+  }
+}
+
+//
+// func (ws *WebSocket) detach(done chan struct{}) io.ReadWriteCloser
+//
+// Marks the connection done belongs to (nil for the current one) as
+// dropped and hands it back to be closed - nil if it already was.  The
+// close itself happens outside ws.mu as it may have to wait
+//
+
+func (ws *WebSocket) detach(done chan struct{}) io.ReadWriteCloser {
+
+  ws.mu.Lock()
+  defer ws.mu.Unlock()
+
+  if(ws.done == nil || (done != nil && ws.done != done)){
+    return nil
+  }
+
+  close(ws.done)
+  ws.done = nil
+
+  return ws.conn
+}
+
+//
+// func (ws *WebSocket) closeConn(conn io.ReadWriteCloser, code int) error
+//
+// Sends a close frame (code 0 for none) unless a write is under way,
+// then closes conn.  Waits at most wsCloseWait for a server that isn't
+// reading - closing conn also frees a write stuck on it
+//
+
+func (ws *WebSocket) closeConn(conn io.ReadWriteCloser, code int) error {
+
+  timer := time.AfterFunc(wsCloseWait, func() { conn.Close() })
+
+  if(code != 0 && ws.wmu.TryLock()){
+    writeFrame(conn, wsClose, closePayload(code, ""))
+    ws.wmu.Unlock()
+  }
+
+  if(!timer.Stop()){
+    return nil // the timer closed it
+  }
+
+  return conn.Close()
+}
+
+//
+// func (ws *WebSocket) write(opcode byte, data []byte) error
+//
+// One frame on the current connection.  Only wmu is held while writing
+// so Close() and the keepalive can still get at the connection
+//
+
+func (ws *WebSocket) write(opcode byte, data []byte) error {
+
+  ws.wmu.Lock()
+  defer ws.wmu.Unlock()
+
+  ws.mu.Lock()
+  conn, closed, dropped := ws.conn, ws.bClosed, ws.done == nil
+  ws.mu.Unlock()
+
+  if(closed){
+    return ErrWebSocketClosed
+  }
+
+  if(dropped){
+    return ws.pRA.transportError(errors.New("websocket: connection lost"))
+  }
+
+  if err := writeFrame(conn, opcode, data); err != nil {
+    return ws.pRA.transportError(err)
+  }
+
+  return nil
+}
+
+//
+// func (ws *WebSocket) readMessage(br *bufio.Reader) (int, []byte, error)
+//
+// Reads frames until a whole message is in, handling control frames
+//
+
+func (ws *WebSocket) readMessage(br *bufio.Reader) (int, []byte, error) {
+
+  limit := ws.pOpts.MaxMessageSize
+
+  if(limit <= 0){
+    limit = wsMaxMessageSize
+  }
+
+  msgType := 0
+  var msg []byte
+
+  for {
+
+    // data frames may only use what is left of the message's allowance
+
+    fin, opcode, payload, err := readFrame(br, limit - int64(len(msg)))
+
+    if(errors.Is(err, errWSTooLarge)){
+      return 0, nil, ws.fail(wsCloseTooBig, err)
+    }
+
+    if(err != nil){
+      return 0, nil, ws.pRA.transportError(err)
+    }
+
+    ws.nLastSeen.Store(time.Now().UnixNano())
+
+    switch opcode {
+
+      case wsPing:
+        ws.write(wsPong, payload)
+        continue
+
+      case wsPong:
+        continue
+
+      case wsClose:
+        closeErr := &CloseError{ Code: wsCloseNoStatus }
+
+        if(len(payload) >= 2){
+          closeErr.Code = int(binary.BigEndian.Uint16(payload))
+          closeErr.Text = string(payload[2:])
+        }
+
+        ws.drop(nil, closeErr.Code) // echo it back
+
+        return 0, nil, closeErr
+
+      case wsContinuation:
+        if(msgType == 0){
+          return 0, nil, ws.fail(wsCloseProtocol, errors.New("websocket: continuation frame with no message"))
+        }
+        msg = append(msg, payload...)
+
+      case TextMessage, BinaryMessage:
+        if(msgType != 0){
+          return 0, nil, ws.fail(wsCloseProtocol, errors.New("websocket: new message before the last one finished"))
+        }
+        msgType = int(opcode)
+        msg = payload
+
+      default:
+        return 0, nil, ws.fail(wsCloseProtocol, fmt.Errorf("websocket: unknown opcode %d", opcode))
+    }
+
+    if(fin){
+      return msgType, msg, nil
+    }
+  }
+}
+
+//
+// func (ws *WebSocket) fail(code int, err error) error
+//
+// The server broke the protocol or sent too much - close the connection
+// with code
+//
+
+func (ws *WebSocket) fail(code int, err error) error {
+
+  ws.drop(nil, code)
+
+  return ws.pRA.transportError(err)
+}
+
+//
+// func (pRA *Restapi) wsHandshake(ctx context.Context, tran *http.Transport, protocols []string) (io.ReadWriteCloser, *Response, error)
+//
+// Sends the upgrade request over tran, the websocket's HTTP/1.1 only
+// transport built from this request's TLS settings.  The request
+// timeout covers just the handshake
+//
+
+func (pRA *Restapi) wsHandshake(ctx context.Context, tran *http.Transport, protocols []string) (io.ReadWriteCloser, *Response, error) {
+
+  pRA.resetResponse()
+
+  ctx, cancel := context.WithCancel(ctx)
+
+  var timer *time.Timer
+
+  if(pRA.dTimeout > 0){
+    timer = time.AfterFunc(pRA.dTimeout, cancel)
+  }
+
+  conn, resp, err := pRA.wsUpgrade(ctx, tran, protocols)
+
+  if(timer != nil){
+    timer.Stop()
+  }
+
+  if(err != nil){
+    cancel()
+    return nil, resp, err
+  }
+
+  return &cancelConn{ ReadWriteCloser: conn, cancel: cancel }, resp, nil
+}
+
+func (pRA *Restapi) wsUpgrade(ctx context.Context, tran *http.Transport, protocols []string) (io.ReadWriteCloser, *Response, error) {
+
+  req, err := pRA.newRequest(ctx)
+
+  if(err != nil){
+    return nil, nil, err
+  }
+
+  switch strings.ToLower(req.URL.Scheme) {
+    case "ws":
+      req.URL.Scheme = "http"
+    case "wss":
+      req.URL.Scheme = "https"
+  }
+
+  keyBytes := make([]byte, 16)
+  rand.Read(keyBytes)
+  key := base64.StdEncoding.EncodeToString(keyBytes)
+
+  req.Header.Set("Connection", "Upgrade")
+  req.Header.Set("Upgrade", "websocket")
+  req.Header.Set("Sec-WebSocket-Version", "13")
+  req.Header.Set("Sec-WebSocket-Key", key)
+
+  if(len(protocols) > 0){
+    req.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+  }
+
+  client := &http.Client{ Transport: tran }
+
+  if(pRA.bDebug){
+    pRA.debugRequest(req)
+  }
+
+  res, err := client.Do(req)
+
+  if(err != nil){
+    return nil, nil, pRA.transportError(err)
+  }
+
+  pRA.nLastStatusCode = res.StatusCode
+
+  resp := newResponse(res)
+
+  pRA.pLastResponse = resp
+
+  if(pRA.bDebug){
+    pRA.debugResponse(res)
+  }
+
+  if(res.StatusCode != http.StatusSwitchingProtocols){
+
+    body, _ := io.ReadAll(io.LimitReader(res.Body, 64 * 1024))
+    res.Body.Close()
+
+    resp.Body = body
+    pRA.BodyBytes = body
+    pRA.BodyString = string(body)
+
+    return nil, resp, pRA.statusError(resp)
+  }
+
+  conn, ok := res.Body.(io.ReadWriteCloser)
+
+  if(!ok){
+    res.Body.Close()
+    return nil, resp, pRA.transportError(errors.New("websocket: upgraded connection is not writable"))
+  }
+
+  sum := sha1.Sum([]byte(key + wsGUID))
+
+  if(!strings.EqualFold(res.Header.Get("Upgrade"), "websocket") ||
+     res.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:])){
+    conn.Close()
+    return nil, resp, pRA.transportError(errors.New("websocket: bad handshake answer from server"))
+  }
+
+  return conn, resp, nil
+}
+
+//
+// func (pRA *Restapi) effectiveTLSOptions() (*TLSOptions, error)
+//
+// The request's (or client's) TLS options with the UseCert() file
+// loaded into RootCAs
+//
+
+func (pRA *Restapi) effectiveTLSOptions() (*TLSOptions, error) {
+
+  opts := pRA.GetTLSOptions()
+
+  if(pRA.bUseCertFile && opts.RootCAs == nil){
+    pool, err := loadCertPool(pRA.sCertFile)
+
+    if(err != nil){
+      return nil, err
+    }
+
+    opts.RootCAs = pool
+  }
+
+  return opts, nil
+}
+
+//
+// Releases the handshake context when the connection is closed
+//
+
+type cancelConn struct {
+  io.ReadWriteCloser
+  cancel context.CancelFunc
+}
+
+func (c *cancelConn) Close() error {
+
+  err := c.ReadWriteCloser.Close()
+  c.cancel()
+
+  return err
+}
+
+//
+// func readFrame(br *bufio.Reader, max int64) (bool, byte, []byte, error)
+//
+// One frame - fin flag, opcode and unmasked payload.  A data frame over
+// max bytes is refused with errWSTooLarge before any of it is read
+//
+
+func readFrame(br *bufio.Reader, max int64) (bool, byte, []byte, error) {
+
+  var head [2]byte
+
+  if _, err := io.ReadFull(br, head[:]); err != nil {
+    return false, 0, nil, err
+  }
+
+  fin := head[0] & 0x80 != 0
+  opcode := head[0] & 0x0f
+
+  if(head[0] & 0x70 != 0){
+    return false, 0, nil, errors.New("websocket: reserved bits set")
+  }
+
+  size := int64(head[1] & 0x7f)
+
+  switch size {
+    case 126:
+      var ext [2]byte
+      if _, err := io.ReadFull(br, ext[:]); err != nil {
+        return false, 0, nil, err
+      }
+      size = int64(binary.BigEndian.Uint16(ext[:]))
+    case 127:
+      var ext [8]byte
+      if _, err := io.ReadFull(br, ext[:]); err != nil {
+        return false, 0, nil, err
+      }
+      size = int64(binary.BigEndian.Uint64(ext[:]))
+  }
+
+  if(opcode >= wsClose){
+    if(size > 125 || !fin){
+      return false, 0, nil, errors.New("websocket: bad control frame")
+    }
+  }else if(size < 0 || size > max){ // < 0 - top bit set in the 64 bit length
+    return false, 0, nil, fmt.Errorf("%w: %d byte frame", errWSTooLarge, uint64(size))
+  }
+
+  var mask [4]byte
+
+  masked := head[1] & 0x80 != 0
+
+  if(masked){
+    if _, err := io.ReadFull(br, mask[:]); err != nil {
+      return false, 0, nil, err
+    }
+  }
+
+  payload, err := readPayload(br, size)
+
+  if(err != nil){
+    return false, 0, nil, err
+  }
+
+  if(masked){
+    for i := range payload {
+      payload[i] ^= mask[i % 4]
+    }
+  }
+
+  return fin, opcode, payload, nil
+}
+
+//
+// func readPayload(r io.Reader, size int64) ([]byte, error)
+//
+// Reads size bytes.  Big payloads are read into a buffer that grows as
+// the data actually arrives, so a header claiming more than the server
+// ever sends costs nothing
+//
+
+func readPayload(r io.Reader, size int64) ([]byte, error) {
+
+  if(size <= wsReadChunk){
+    payload := make([]byte, size)
+
+    _, err := io.ReadFull(r, payload)
+
+    return payload, err
+  }
+
+  var buf bytes.Buffer
+
+  buf.Grow(wsReadChunk)
+
+  if _, err := io.CopyN(&buf, r, size); err != nil {
+    if(err == io.EOF){
+      err = io.ErrUnexpectedEOF
+    }
+    return nil, err
+  }
+
+  return buf.Bytes(), nil
+}
+
+//
+// func writeFrame(w io.Writer, opcode byte, payload []byte) error
+//
+// One final, masked (as a client must) frame
+//
+
+func writeFrame(w io.Writer, opcode byte, payload []byte) error {
+
+  frame := make([]byte, 0, 14 + len(payload))
+
+  frame = append(frame, 0x80 | opcode)
+
+  switch n := len(payload); {
+    case n <= 125:
+      frame = append(frame, 0x80 | byte(n))
+    case n <= 0xffff:
+      frame = append(frame, 0x80 | 126)
+      frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+    default:
+      frame = append(frame, 0x80 | 127)
+      frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+  }
+
+  var mask [4]byte
+  rand.Read(mask[:])
+
+  frame = append(frame, mask[:]...)
+
+  for i, b := range payload {
+    frame = append(frame, b ^ mask[i % 4])
+  }
+
+  _, err := w.Write(frame)
+
+  return err
+}
+
+func closePayload(code int, text string) []byte {
+
+  if(code == wsCloseNoStatus){
+    return nil // not allowed on the wire
+  }
+
+  return append(binary.BigEndian.AppendUint16(nil, uint16(code)), text...)
+}
@@ -0,0 +1,479 @@
+package restapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// the server end of a test websocket
+
+type wsPeer struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// one unmasked frame, as a server sends them
+
+func (p *wsPeer) send(fin bool, opcode byte, payload []byte) error {
+
+	head := opcode
+	if fin {
+		head |= 0x80
+	}
+
+	frame := []byte{head}
+
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	_, err := p.conn.Write(append(frame, payload...))
+
+	return err
+}
+
+func (p *wsPeer) recv(t *testing.T) (byte, []byte) {
+
+	t.Helper()
+
+	p.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	fin, opcode, payload, err := readFrame(p.br, 1<<24)
+
+	if err != nil {
+		t.Errorf("server read: %v", err)
+		return 0, nil
+	}
+
+	if !fin {
+		t.Errorf("client sent a fragment")
+	}
+
+	return opcode, payload
+}
+
+func wsServer(t *testing.T, handler func(p *wsPeer)) *httptest.Server {
+
+	srv := httptest.NewServer(wsHandler(t, handler))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func wsHandler(t *testing.T, handler func(p *wsPeer)) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		key := r.Header.Get("Sec-WebSocket-Key")
+
+		if r.Header.Get("Upgrade") != "websocket" || key == "" {
+			t.Errorf("not an upgrade request: %v", r.Header)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, brw, err := w.(http.Hijacker).Hijack()
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		defer conn.Close()
+
+		sum := sha1.Sum([]byte(key + wsGUID))
+
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+		brw.Flush()
+
+		handler(&wsPeer{conn: conn, br: brw.Reader})
+	})
+}
+
+// a certificate nothing trusts
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "impostor"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func dialTestWebSocket(t *testing.T, srv *httptest.Server, maxSize int64) *WebSocket {
+
+	t.Helper()
+
+	opts := DefaultWebSocketOptions()
+	opts.PingInterval = 0
+	opts.Reconnect = false
+	opts.MaxMessageSize = maxSize
+
+	r := NewClient().NewGet("ws test", "ws"+strings.TrimPrefix(srv.URL, "http"))
+
+	ws, err := r.DialWebSocket(context.Background(), opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { ws.Close() })
+
+	return ws
+}
+
+func TestWebSocketRoundTrip(t *testing.T) {
+
+	srv := wsServer(t, func(p *wsPeer) {
+		for i := 0; i < 3; i++ {
+			opcode, payload := p.recv(t)
+			p.send(true, opcode, payload)
+		}
+	})
+
+	ws := dialTestWebSocket(t, srv, 0)
+
+	big := bytes.Repeat([]byte("0123456789"), 10000) // 64 bit length, read in chunks
+
+	msgs := []struct {
+		msgType int
+		data    []byte
+	}{
+		{TextMessage, []byte("hello")},
+		{BinaryMessage, bytes.Repeat([]byte{0xff}, 300)}, // 16 bit length
+		{BinaryMessage, big},
+	}
+
+	for _, m := range msgs {
+
+		if err := ws.WriteMessage(m.msgType, m.data); err != nil {
+			t.Fatal(err)
+		}
+
+		msgType, data, err := ws.ReadMessage()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msgType != m.msgType || !bytes.Equal(data, m.data) {
+			t.Errorf("got type %d, %d bytes - want type %d, %d bytes", msgType, len(data), m.msgType, len(m.data))
+		}
+	}
+}
+
+func TestWebSocketFragmentsAndPing(t *testing.T) {
+
+	pong := make(chan []byte, 1)
+
+	srv := wsServer(t, func(p *wsPeer) {
+
+		p.send(false, TextMessage, []byte("hel"))
+		p.send(true, wsPing, []byte("are you there"))
+		p.send(false, wsContinuation, []byte("lo "))
+		p.send(true, wsContinuation, []byte("world"))
+
+		opcode, payload := p.recv(t)
+
+		if opcode != wsPong {
+			t.Errorf("opcode %d, want pong", opcode)
+		}
+
+		pong <- payload
+	})
+
+	ws := dialTestWebSocket(t, srv, 0)
+
+	msgType, data, err := ws.ReadMessage()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msgType != TextMessage || string(data) != "hello world" {
+		t.Errorf("got %d %q", msgType, data)
+	}
+
+	select {
+	case got := <-pong:
+		if string(got) != "are you there" {
+			t.Errorf("pong payload %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no pong")
+	}
+}
+
+func TestWebSocketServerClose(t *testing.T) {
+
+	echoed := make(chan []byte, 1)
+
+	srv := wsServer(t, func(p *wsPeer) {
+
+		p.send(true, wsClose, closePayload(wsCloseNormal, "bye"))
+
+		opcode, payload := p.recv(t)
+
+		if opcode != wsClose {
+			t.Errorf("opcode %d, want close", opcode)
+		}
+
+		echoed <- payload
+	})
+
+	ws := dialTestWebSocket(t, srv, 0)
+
+	_, _, err := ws.ReadMessage()
+
+	var closeErr *CloseError
+
+	if !errors.As(err, &closeErr) || closeErr.Code != wsCloseNormal || closeErr.Text != "bye" {
+		t.Fatalf("err = %v, want close 1000 bye", err)
+	}
+
+	if !errors.Is(err, ErrWebSocketClosed) {
+		t.Errorf("CloseError should match ErrWebSocketClosed")
+	}
+
+	if got := <-echoed; binary.BigEndian.Uint16(got) != wsCloseNormal {
+		t.Errorf("echoed close %v", got)
+	}
+
+	if err := ws.WriteMessage(TextMessage, []byte("x")); err == nil {
+		t.Errorf("write after close worked")
+	}
+}
+
+func TestWebSocketMessageTooLarge(t *testing.T) {
+
+	closed := make(chan []byte, 1)
+
+	srv := wsServer(t, func(p *wsPeer) {
+
+		// each frame fits, the message doesn't
+
+		p.send(false, BinaryMessage, []byte("123456"))
+		p.send(true, wsContinuation, []byte("789012"))
+
+		_, payload := p.recv(t)
+
+		closed <- payload
+	})
+
+	ws := dialTestWebSocket(t, srv, 10)
+
+	if _, _, err := ws.ReadMessage(); !errors.Is(err, errWSTooLarge) {
+		t.Fatalf("err = %v, want too large", err)
+	}
+
+	if got := <-closed; len(got) < 2 || binary.BigEndian.Uint16(got) != wsCloseTooBig {
+		t.Errorf("close payload %v, want 1009", got)
+	}
+}
+
+func TestWebSocketFrameLengthCap(t *testing.T) {
+
+	// a header claiming far more than the cap is refused without reading
+	// (or allocating) the payload
+
+	for _, size := range []uint64{1 << 62, 1 << 63, wsMaxMessageSize + 1} {
+
+		head := binary.BigEndian.AppendUint64([]byte{0x82, 127}, size)
+
+		_, _, _, err := readFrame(bufio.NewReader(bytes.NewReader(head)), wsMaxMessageSize)
+
+		if !errors.Is(err, errWSTooLarge) {
+			t.Errorf("size %d: err = %v", size, err)
+		}
+	}
+
+	// a big frame that never arrives fails cleanly
+
+	head := binary.BigEndian.AppendUint64([]byte{0x82, 127}, 10<<20)
+
+	_, _, _, err := readFrame(bufio.NewReader(bytes.NewReader(append(head, "short"...))), wsMaxMessageSize)
+
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("err = %v, want unexpected EOF", err)
+	}
+}
+
+func TestWebSocketUnknownOpcode(t *testing.T) {
+
+	closed := make(chan []byte, 1)
+
+	srv := wsServer(t, func(p *wsPeer) {
+
+		p.send(true, 0x3, []byte("?"))
+
+		_, payload := p.recv(t)
+
+		closed <- payload
+	})
+
+	ws := dialTestWebSocket(t, srv, 0)
+
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Fatal("no error for opcode 3")
+	}
+
+	if got := <-closed; len(got) < 2 || binary.BigEndian.Uint16(got) != wsCloseProtocol {
+		t.Errorf("close payload %v, want 1002", got)
+	}
+}
+
+func TestWebSocketCloseStuckWrite(t *testing.T) {
+
+	release := make(chan struct{})
+
+	srv := wsServer(t, func(p *wsPeer) {
+		<-release // never reads
+	})
+
+	defer close(release)
+
+	ws := dialTestWebSocket(t, srv, 0)
+
+	writeErr := make(chan error, 1)
+
+	go func() {
+		chunk := make([]byte, 1<<20)
+		for {
+			if err := ws.WriteMessage(BinaryMessage, chunk); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+	}()
+
+	time.Sleep(200 * time.Millisecond) // let the socket buffers fill
+
+	closed := make(chan error, 1)
+
+	go func() { closed <- ws.Close() }()
+
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Close() blocked behind a stuck write")
+	}
+
+	select {
+	case err := <-writeErr:
+		if err == nil {
+			t.Error("stuck write returned nil")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("stuck write never returned")
+	}
+}
+
+func TestWebSocketRedialBadCertificate(t *testing.T) {
+
+	var dials atomic.Int32
+
+	srv := httptest.NewUnstartedServer(wsHandler(t, func(p *wsPeer) {
+		dials.Add(1)
+		p.send(true, wsClose, closePayload(wsCloseGoingAway, "restarting"))
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+
+	// after the first connection the server comes back with a certificate
+	// the client doesn't trust
+
+	impostor := selfSignedCert(t)
+
+	var swapped atomic.Bool
+
+	srv.TLS = &tls.Config{GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		if swapped.Load() {
+			return &tls.Config{Certificates: []tls.Certificate{impostor}}, nil
+		}
+		return nil, nil
+	}}
+
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	r := NewClient().NewGet("ws test", "wss"+strings.TrimPrefix(srv.URL, "https"))
+	r.SetTLSOptions(&TLSOptions{RootCAs: pool})
+
+	opts := DefaultWebSocketOptions()
+	opts.PingInterval = 0
+	opts.ReconnectDelay = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws, err := r.DialWebSocket(ctx, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ws.Close()
+
+	swapped.Store(true)
+
+	_, _, err = ws.ReadMessage()
+
+	var unknownAuth x509.UnknownAuthorityError
+
+	if ctx.Err() != nil || !errors.As(err, &unknownAuth) {
+		t.Fatalf("err = %v, want the certificate error", err)
+	}
+
+	if dials.Load() != 1 {
+		t.Errorf("%d connections, want 1", dials.Load())
+	}
+}